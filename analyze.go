@@ -0,0 +1,402 @@
+package rsi
+
+import (
+	"errors"
+	"fmt"
+)
+
+// execution is an analyzed expression, ready to be run in an
+// environment. Expressions are analyzed once, so the dispatch on special
+// forms and the checks of their syntax are not repeated each time they
+// are evaluated.
+type execution func(env *Env) (Data, error)
+
+// eval analyzes or compiles expr, depending on the interpreter's
+// engine, and runs it in env.
+func eval(expr Data, env *Env) (Data, error) {
+	env.interp.log.Printf("eval: %T: %v\n", expr, expr)
+	if env.interp.engine == VM {
+		c, err := compile(expr, scopeOf(env), env.interp)
+		if err != nil {
+			return nil, err
+		}
+		return env.interp.run(c, env)
+	}
+	x, err := analyze(expr, scopeOf(env), env.interp)
+	if err != nil {
+		return nil, err
+	}
+	return x(env)
+}
+
+// analyze returns the execution of expr, which is to be run in a frame
+// of scope sc, or at the top level if sc is nil. Each execution takes a
+// step when it runs, so the step limit counts expressions evaluated.
+func analyze(expr Data, sc *scope, in *Interpreter) (execution, error) {
+	switch e := expr.(type) {
+	case Boolean, Number, String, Null, *Vector:
+		return constant(e), nil
+	case Symbol:
+		return analyzeVariable(e, sc, in), nil
+	case *Pair:
+		c, _ := getSymbol(e.car)
+		/* non-Symbols fall through to default */
+		switch in.syms.forms[c] {
+		case formQuote:
+			return constant(cadr(e)), nil
+		case formDefine:
+			return analyzeDefine(e, sc, in)
+		case formSet:
+			return analyzeSet(e, sc, in)
+		case formIf:
+			return analyzeIf(e, sc, in)
+		case formLet:
+			x, err := expandLet(e.cdr, in.syms)
+			if err != nil {
+				return nil, err
+			}
+			return analyze(x, sc, in)
+		case formLetrec:
+			x, err := expandLetrec(e.cdr, in.syms)
+			if err != nil {
+				return nil, err
+			}
+			return analyze(x, sc, in)
+		case formBegin:
+			return analyzeSequence(e.cdr, sc, in)
+		case formQuit:
+			return func(env *Env) (Data, error) {
+				if err := env.interp.step(); err != nil {
+					return nil, err
+				}
+				return nil, &ExitError{0}
+			}, nil
+		case formLambda:
+			return analyzeLambda(cadr(e), cddr(e), sc, in)
+		}
+		return analyzeApplication(e, sc, in)
+	case nil:
+		return nil, errors.New("cannot evaluate a missing expression")
+	}
+	return nil, fmt.Errorf("Unparsable expression: %v", expr)
+}
+
+func constant(d Data) execution {
+	return func(env *Env) (Data, error) {
+		if err := env.interp.step(); err != nil {
+			return nil, err
+		}
+		return d, nil
+	}
+}
+
+func analyzeDefine(e *Pair, sc *scope, in *Interpreter) (execution, error) {
+	defn, err := getPair(e.cdr)
+	if err != nil {
+		return nil, err
+	}
+	var name Symbol
+	var value execution
+	switch target := defn.car.(type) {
+	// (define var value)
+	case Symbol:
+		name = target
+		value, err = analyze(cadr(defn), sc, in)
+	// (define (proc a b) (body))
+	case *Pair:
+		name, err = getSymbol(target.car)
+		if err != nil {
+			return nil, err
+		}
+		value, err = analyzeLambda(target.cdr, defn.cdr, sc, in)
+	default:
+		return nil, fmt.Errorf("cannot define %v", target)
+	}
+	if err != nil {
+		return nil, err
+	}
+	assign, err := definition(name, sc, in)
+	if err != nil {
+		return nil, err
+	}
+	return func(env *Env) (Data, error) {
+		if err := env.interp.step(); err != nil {
+			return nil, err
+		}
+		v, err := value(env)
+		if err != nil {
+			return nil, err
+		}
+		nameLambda(v, name)
+		assign(env, v)
+		// Return value of define is undefined
+		return env.interp.syms.ok, nil
+	}, nil
+}
+
+// nameLambda gives the name a procedure is defined with to v, if it is
+// an anonymous procedure.
+func nameLambda(v Data, name Symbol) {
+	if l, ok := v.(*Lambda); ok && l.name == "" {
+		l.name = name
+	}
+}
+
+func analyzeSet(e *Pair, sc *scope, in *Interpreter) (execution, error) {
+	name, err := getSymbol(cadr(e))
+	if err != nil {
+		return nil, err
+	}
+	value, err := analyze(caddr(e), sc, in)
+	if err != nil {
+		return nil, err
+	}
+	assign := assignment(name, sc, in)
+	return func(env *Env) (Data, error) {
+		if err := env.interp.step(); err != nil {
+			return nil, err
+		}
+		v, err := value(env)
+		if err != nil {
+			return nil, err
+		}
+		if err := assign(env, v); err != nil {
+			return nil, err
+		}
+		return env.interp.syms.ok, nil
+	}, nil
+}
+
+func analyzeIf(e *Pair, sc *scope, in *Interpreter) (execution, error) {
+	test, err := analyze(cadr(e), sc, in)
+	if err != nil {
+		return nil, err
+	}
+	consequent, err := analyze(caddr(e), sc, in)
+	if err != nil {
+		return nil, err
+	}
+	alternative := execution(func(*Env) (Data, error) { return Empty, nil })
+	if listLen(e) > 3 {
+		if alternative, err = analyze(cadddr(e), sc, in); err != nil {
+			return nil, err
+		}
+	}
+	return func(env *Env) (Data, error) {
+		if err := env.interp.step(); err != nil {
+			return nil, err
+		}
+		t, err := test(env)
+		if err != nil {
+			return nil, err
+		}
+		if isTrue(t) {
+			return consequent(env)
+		}
+		return alternative(env)
+	}, nil
+}
+
+// analyzeSequence analyzes the non-empty list of expressions body,
+// which are run in turn for the value of the last.
+func analyzeSequence(body Data, sc *scope, in *Interpreter) (execution, error) {
+	if _, err := getPair(body); err != nil {
+		return nil, err
+	}
+	items, err := listSlice(body)
+	if err != nil {
+		return nil, err
+	}
+	xs := make([]execution, len(items))
+	for i, item := range items {
+		if xs[i], err = analyze(item, sc, in); err != nil {
+			return nil, err
+		}
+	}
+	if len(xs) == 1 {
+		return xs[0], nil
+	}
+	return func(env *Env) (Data, error) {
+		if err := env.interp.step(); err != nil {
+			return nil, err
+		}
+		for _, x := range xs[:len(xs)-1] {
+			if _, err := x(env); err != nil {
+				return nil, err
+			}
+		}
+		return xs[len(xs)-1](env)
+	}, nil
+}
+
+// lambdaParams checks the parameter list and body of a lambda
+// expression, returning the parameter names.
+func lambdaParams(params Data, body Data) ([]Symbol, error) {
+	var names []Symbol
+	for params != Empty {
+		p, err := getPair(params)
+		if err != nil {
+			return nil, fmt.Errorf("bad params: not a pair here %v", params)
+		}
+		switch arg := p.car.(type) {
+		case Symbol:
+			names = append(names, arg)
+		case *Pair:
+			return nil, fmt.Errorf("combo param not supported: %v", arg)
+		default:
+			return nil, fmt.Errorf("bad params: not a symbol %v", arg)
+		}
+		params = p.cdr
+	}
+	if _, err := getList(body); err != nil {
+		return nil, fmt.Errorf("bad body: %v", err)
+	}
+	return names, nil
+}
+
+func analyzeLambda(params Data, body Data, sc *scope, in *Interpreter) (execution, error) {
+	names, err := lambdaParams(params, body)
+	if err != nil {
+		return nil, err
+	}
+	frame := frameNames(names, body, in.syms)
+	inner := &scope{names: frame, outer: sc}
+	var x execution
+	if nullp(body) {
+		// an empty body is only an error if the procedure is called
+		_, err := getPair(body)
+		x = func(*Env) (Data, error) { return nil, err }
+	} else {
+		if x, err = analyzeSequence(body, inner, in); err != nil {
+			return nil, err
+		}
+	}
+	return func(env *Env) (Data, error) {
+		if err := env.interp.step(); err != nil {
+			return nil, err
+		}
+		l := env.interp.newLambda()
+		l.params = names
+		l.frame = frame
+		l.body = x
+		l.envt = env
+		return l, nil
+	}, nil
+}
+
+func analyzeApplication(e *Pair, sc *scope, in *Interpreter) (execution, error) {
+	operator, err := analyze(e.car, sc, in)
+	if err != nil {
+		return nil, err
+	}
+	items, err := listSlice(e.cdr)
+	if err != nil {
+		return nil, err
+	}
+	operands := make([]execution, len(items))
+	for i, item := range items {
+		if operands[i], err = analyze(item, sc, in); err != nil {
+			return nil, err
+		}
+	}
+	return func(env *Env) (Data, error) {
+		env.interp.log.Printf("procedure call %v", e)
+		if err := env.interp.step(); err != nil {
+			return nil, err
+		}
+		proc, err := operator(env)
+		if err != nil {
+			return nil, err
+		}
+		args := make([]Data, len(operands))
+		for i, x := range operands {
+			if args[i], err = x(env); err != nil {
+				return nil, err
+			}
+		}
+		var v Data
+		if l, ok := proc.(*Lambda); ok && !l.traced {
+			if err = env.interp.enter(); err == nil {
+				v, err = env.interp.call(l, args)
+				env.interp.depth--
+			}
+		} else {
+			v, err = env.interp.apply(proc, List(args...))
+		}
+		if err != nil {
+			return nil, addFrame(err, proc, e)
+		}
+		return v, nil
+	}, nil
+}
+
+// analyzeVariable returns the execution of a reference to sym, found by
+// its lexical address or global cell.
+func analyzeVariable(sym Symbol, sc *scope, in *Interpreter) execution {
+	depth, i, ok := sc.lookup(sym)
+	switch {
+	case !ok:
+		c := in.env.cell(sym)
+		return func(env *Env) (Data, error) {
+			if err := env.interp.step(); err != nil {
+				return nil, err
+			}
+			return c.get()
+		}
+	case depth == 0:
+		return func(env *Env) (Data, error) {
+			if err := env.interp.step(); err != nil {
+				return nil, err
+			}
+			return env.local(i)
+		}
+	}
+	return func(env *Env) (Data, error) {
+		if err := env.interp.step(); err != nil {
+			return nil, err
+		}
+		return env.frame(depth).local(i)
+	}
+}
+
+// assignment returns a function for set! that assigns to the variable
+// sym, which must already be bound.
+func assignment(sym Symbol, sc *scope, in *Interpreter) func(*Env, Data) error {
+	depth, i, ok := sc.lookup(sym)
+	if !ok {
+		c := in.env.cell(sym)
+		return func(_ *Env, v Data) error {
+			return c.assign(v)
+		}
+	}
+	return func(env *Env, v Data) error {
+		return env.frame(depth).assign(i, v)
+	}
+}
+
+// definition returns a function for define that binds a global or a
+// name defined in the body being analyzed.
+func definition(sym Symbol, sc *scope, in *Interpreter) (func(*Env, Data), error) {
+	if err := checkDefine(sym, sc); err != nil {
+		return nil, err
+	}
+	if sc == nil {
+		c := in.env.cell(sym)
+		return func(env *Env, v Data) {
+			env.interp.define(c, v)
+		}, nil
+	}
+	i := indexOf(sc.names, sym)
+	return func(env *Env, v Data) {
+		env.slots[i] = v
+	}, nil
+}
+
+// checkDefine reports an error unless sym can be defined in scope sc,
+// which holds the names defined in the body of its procedure.
+func checkDefine(sym Symbol, sc *scope) error {
+	if sc != nil && indexOf(sc.names, sym) < 0 {
+		return fmt.Errorf("cannot define %v here, only at the top level or in a body", sym)
+	}
+	return nil
+}
@@ -0,0 +1,36 @@
+package rsi
+
+import "testing"
+
+// benchmark evaluates setup once, then expr b.N times. It is named for
+// the engine, as TestMain runs the benchmarks with each.
+func benchmark(b *testing.B, setup, expr string) {
+	b.Run(defaultEngine.String(), func(b *testing.B) {
+		in := New()
+		if _, err := in.Eval(setup); err != nil {
+			b.Fatal(err)
+		}
+		b.ResetTimer()
+		for n := 0; n < b.N; n++ {
+			v, err := in.Eval(expr)
+			if err != nil {
+				b.Fatal(err)
+			}
+			result = v
+		}
+	})
+}
+
+func BenchmarkFib(b *testing.B) {
+	benchmark(b, "(define (fib n) (if (< n 2) n (+ (fib (- n 1)) (fib (- n 2)))))", "(fib 15)")
+}
+
+func BenchmarkClosures(b *testing.B) {
+	benchmark(b, `(define (make-adder n) (lambda (x) (+ x n)))
+		(define (apply-n f n x) (if (= n 0) x (apply-n f (- n 1) (f x))))`,
+		"(apply-n (make-adder 2) 200 0)")
+}
+
+func BenchmarkLet(b *testing.B) {
+	benchmark(b, "(define (sum n) (if (= n 0) 0 (let ((m (- n 1))) (+ n (sum m)))))", "(sum 200)")
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/rread/rsi"
+)
+
+// session is the state of an interactive REPL.
+type session struct {
+	in   *rsi.Interpreter
+	out  io.Writer
+	done bool
+	opts []rsi.Option // used to create a fresh interpreter on reset
+}
+
+func newSession(out io.Writer, opts ...rsi.Option) *session {
+	return &session{in: rsi.New(opts...), out: out, opts: opts}
+}
+
+type command struct {
+	name  string
+	usage string
+	help  string
+	run   func(s *session, arg string) error
+}
+
+var commands []command
+
+func init() {
+	commands = []command{
+		{"help", "", "list the REPL commands", cmdHelp},
+		{"env", "", "list the global bindings and their values", cmdEnv},
+		{"describe", "sym", "describe the value bound to sym", cmdDescribe},
+		{"load", "file", "evaluate the forms in file", cmdLoad},
+		{"time", "expr", "evaluate expr and report the time taken", cmdTime},
+		{"trace", "proc", "toggle tracing of calls to proc", cmdTrace},
+		{"expand", "form", "show form with derived expressions expanded", cmdExpand},
+		{"reset", "", "start again with a fresh environment", cmdReset},
+		{"quit", "", "leave the REPL", cmdQuit},
+	}
+}
+
+// isCommand reports whether line is a REPL command rather than a form.
+func isCommand(line string) bool {
+	line = strings.TrimSpace(line)
+	return strings.HasPrefix(line, ",") || strings.HasPrefix(line, ":")
+}
+
+// runCommand executes a ",name arg" or ":name arg" command line.
+func runCommand(s *session, line string) error {
+	line = strings.TrimSpace(line)[1:]
+	name, arg := line, ""
+	if i := strings.IndexFunc(line, isSpace); i >= 0 {
+		name, arg = line[:i], strings.TrimSpace(line[i:])
+	}
+	for _, c := range commands {
+		if c.name == name {
+			if c.usage != "" && arg == "" {
+				return fmt.Errorf("usage: ,%s %s", c.name, c.usage)
+			}
+			return c.run(s, arg)
+		}
+	}
+	return fmt.Errorf("unknown command ,%s (try ,help)", name)
+}
+
+func isSpace(r rune) bool {
+	return r == ' ' || r == '\t' || r == '\n'
+}
+
+func cmdHelp(s *session, arg string) error {
+	for _, c := range commands {
+		fmt.Fprintf(s.out, "  ,%-18s %s\n", strings.TrimSpace(c.name+" "+c.usage), c.help)
+	}
+	return nil
+}
+
+func cmdEnv(s *session, arg string) error {
+	env := s.in.Env()
+	for _, name := range env.Names() {
+		v, _ := env.Var(name)
+		fmt.Fprintf(s.out, "%v: %v\n", name, v)
+	}
+	return nil
+}
+
+func cmdDescribe(s *session, arg string) error {
+	v, err := s.in.Lookup(arg)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(s.out, "%v: %v\n", s.in.Intern(arg), rsi.Describe(v))
+	return nil
+}
+
+func cmdLoad(s *session, arg string) error {
+	result, err := s.in.Load(arg)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(s.out, result)
+	return nil
+}
+
+func cmdTime(s *session, arg string) error {
+	start := time.Now()
+	result, err := s.in.Eval(arg)
+	elapsed := time.Since(start)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(s.out, result)
+	fmt.Fprintf(s.out, "; elapsed %v\n", elapsed)
+	return nil
+}
+
+func cmdTrace(s *session, arg string) error {
+	sym := s.in.Intern(arg)
+	on, err := s.in.Trace(arg)
+	if err != nil {
+		return err
+	}
+	if on {
+		fmt.Fprintf(s.out, "tracing %v\n", sym)
+	} else {
+		fmt.Fprintf(s.out, "untraced %v\n", sym)
+	}
+	return nil
+}
+
+func cmdExpand(s *session, arg string) error {
+	e, err := s.in.Expand(arg)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(s.out, e)
+	return nil
+}
+
+func cmdReset(s *session, arg string) error {
+	s.in = rsi.New(s.opts...)
+	fmt.Fprintln(s.out, "environment reset")
+	return nil
+}
+
+func cmdQuit(s *session, arg string) error {
+	s.done = true
+	return nil
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/rread/rsi"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestCommands(t *testing.T) {
+	Convey("REPL commands", t, func() {
+		var out bytes.Buffer
+		s := newSession(&out)
+		_, err := s.in.Eval("(define (double x) (* x 2))")
+		So(err, ShouldBeNil)
+
+		Convey("are recognised by their prefix", func() {
+			So(isCommand(",help"), ShouldBeTrue)
+			So(isCommand("  :env"), ShouldBeTrue)
+			So(isCommand("(+ 1 2)"), ShouldBeFalse)
+		})
+
+		Convey("describe a binding", func() {
+			So(runCommand(s, ",describe double"), ShouldBeNil)
+			So(out.String(), ShouldEqual,
+				"DOUBLE: compound procedure #<procedure DOUBLE (X)> taking 1 argument\n")
+		})
+
+		Convey("list the environment", func() {
+			So(runCommand(s, ":env"), ShouldBeNil)
+			So(out.String(), ShouldContainSubstring, "DOUBLE: #<procedure DOUBLE (X)>\n")
+			So(out.String(), ShouldContainSubstring, "CAR: #<procedure CAR>\n")
+		})
+
+		Convey("expand derived forms", func() {
+			So(runCommand(s, ",expand (let ((a 1)) (let ((b a)) b))"), ShouldBeNil)
+			So(out.String(), ShouldEqual, "((LAMBDA (A) ((LAMBDA (B) B) A)) 1)\n")
+			out.Reset()
+			So(runCommand(s, ",expand (letrec ((a 1)) a)"), ShouldBeNil)
+			So(out.String(), ShouldEqual, "((LAMBDA () (DEFINE A 1) A))\n")
+			err := runCommand(s, ",expand #0=(let ((a 1)) . #0#)")
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "circular code")
+		})
+
+		Convey("trace procedure calls", func() {
+			var trace bytes.Buffer
+			s.in.TraceOutput = &trace
+			So(runCommand(s, ",trace double"), ShouldBeNil)
+			_, err := s.in.Eval("(double (double 1))")
+			So(err, ShouldBeNil)
+			So(trace.String(), ShouldEqual,
+				"|(DOUBLE 1)\n|DOUBLE => 2\n|(DOUBLE 2)\n|DOUBLE => 4\n")
+			So(runCommand(s, ",trace double"), ShouldBeNil)
+			So(out.String(), ShouldEqual, "tracing DOUBLE\nuntraced DOUBLE\n")
+		})
+
+		Convey("time an expression", func() {
+			So(runCommand(s, ",time (double 21)"), ShouldBeNil)
+			So(out.String(), ShouldStartWith, "42\n; elapsed ")
+		})
+
+		Convey("reset and quit", func() {
+			So(runCommand(s, ",reset"), ShouldBeNil)
+			_, err := s.in.Lookup("double")
+			So(err, ShouldNotBeNil)
+			So(runCommand(s, ",quit"), ShouldBeNil)
+			So(s.done, ShouldBeTrue)
+		})
+
+		Convey("reset keeps the session options", func() {
+			s := newSession(&out, rsi.WithDialect(rsi.R7RS))
+			So(runCommand(s, ",reset"), ShouldBeNil)
+			So(s.in.Dialect(), ShouldEqual, rsi.R7RS)
+		})
+
+		Convey("report misuse", func() {
+			So(runCommand(s, ",describe"), ShouldNotBeNil)
+			So(runCommand(s, ",bogus"), ShouldNotBeNil)
+		})
+	})
+}
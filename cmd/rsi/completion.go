@@ -0,0 +1,129 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/rread/rsi"
+)
+
+// complete returns the completions of word for the REPL. line is the
+// whole input line containing word.
+func complete(in *rsi.Interpreter, word, line string) []string {
+	before := line
+	if i := strings.LastIndex(line, word); i >= 0 {
+		before = line[:i]
+	}
+	if strings.TrimSpace(before) == "" && isCommand(word) {
+		return completeCommand(word)
+	}
+	if isCommand(before) {
+		if name := strings.Fields(before)[0]; name[1:] == "load" {
+			return completeFile(word)
+		}
+		return completeSymbol(in, word)
+	}
+	if inString(before) {
+		if strings.HasSuffix(strings.TrimRight(before, "\" \t"), "(load") {
+			return completeFile(word)
+		}
+		return nil
+	}
+	return completeSymbol(in, word)
+}
+
+// inString reports whether s ends inside a string literal.
+func inString(s string) bool {
+	in := false
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			if in {
+				i++
+			}
+		case '"':
+			in = !in
+		case ';':
+			if !in {
+				return false
+			}
+		}
+	}
+	return in
+}
+
+// completeCommand completes a REPL command name, keeping its prefix.
+func completeCommand(word string) []string {
+	var names []string
+	for _, c := range commands {
+		if strings.HasPrefix(c.name, word[1:]) {
+			names = append(names, word[:1]+c.name)
+		}
+	}
+	return names
+}
+
+// completeSymbol matches word against the global names and the special
+// forms. Legacy symbols are
+// interned in upper case, so there a lower case word completes to lower
+// case names.
+func completeSymbol(in *rsi.Interpreter, word string) []string {
+	prefix := string(in.Intern(word))
+	lower := in.Dialect() == rsi.Legacy && word == strings.ToLower(word)
+	seen := make(map[rsi.Symbol]bool)
+	var names []string
+	add := func(sym rsi.Symbol) {
+		if seen[sym] || !strings.HasPrefix(string(sym), prefix) {
+			return
+		}
+		seen[sym] = true
+		name := string(sym)
+		if lower {
+			name = strings.ToLower(name)
+		}
+		names = append(names, name)
+	}
+	for _, sym := range in.Env().Names() {
+		add(sym)
+	}
+	for _, sym := range in.SpecialForms() {
+		add(sym)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// completeFile returns the paths starting with word, directories are
+// given a trailing separator.
+func completeFile(word string) []string {
+	dir, base := filepath.Split(word)
+	search := dir
+	if search == "" {
+		search = "."
+	}
+	if strings.HasPrefix(search, "~/") {
+		search = filepath.Join(os.Getenv("HOME"), search[2:])
+	}
+	entries, err := ioutil.ReadDir(search)
+	if err != nil {
+		return nil
+	}
+	var names []string
+	for _, fi := range entries {
+		if !strings.HasPrefix(fi.Name(), base) {
+			continue
+		}
+		if strings.HasPrefix(fi.Name(), ".") && !strings.HasPrefix(base, ".") {
+			continue
+		}
+		name := dir + fi.Name()
+		if fi.IsDir() {
+			name += string(filepath.Separator)
+		}
+		names = append(names, name)
+	}
+	return names
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/rread/rsi"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestCompletion(t *testing.T) {
+	Convey("tab completion", t, func() {
+		in := rsi.New()
+		_, err := in.Eval("(define proc-list '())")
+		So(err, ShouldBeNil)
+
+		Convey("completes bound symbols in the case typed", func() {
+			So(complete(in, "proc", "(proc"), ShouldResemble,
+				[]string{"proc-list", "procedure-arity", "procedure-name", "procedure?"})
+			So(complete(in, "PROCEDURE-N", "(PROCEDURE-N"), ShouldResemble,
+				[]string{"PROCEDURE-NAME"})
+		})
+
+		Convey("matches case exactly in R7RS", func() {
+			in := rsi.New(rsi.WithDialect(rsi.R7RS))
+			_, err := in.Eval("(define Proc-list '())")
+			So(err, ShouldBeNil)
+			So(complete(in, "Proc", "(Proc"), ShouldResemble, []string{"Proc-list"})
+			So(complete(in, "procedure-n", "(procedure-n"), ShouldResemble, []string{"procedure-name"})
+		})
+
+		Convey("completes special forms", func() {
+			in.Define("lambda-count", rsi.Number(1))
+			So(complete(in, "lam", "(lam"), ShouldResemble,
+				[]string{"lambda", "lambda-count"})
+		})
+
+		Convey("completes REPL commands", func() {
+			So(complete(in, ",de", ",de"), ShouldResemble, []string{",describe"})
+			So(complete(in, "proc-", ",describe proc-"), ShouldResemble, []string{"proc-list"})
+		})
+
+		Convey("completes file names for load", func() {
+			dir, err := ioutil.TempDir("", "rsi")
+			So(err, ShouldBeNil)
+			defer os.RemoveAll(dir)
+			So(ioutil.WriteFile(filepath.Join(dir, "fact.scm"), nil, 0644), ShouldBeNil)
+			So(os.Mkdir(filepath.Join(dir, "facts"), 0755), ShouldBeNil)
+
+			word := filepath.Join(dir, "fa")
+			So(complete(in, word, `(load "`+word), ShouldResemble,
+				[]string{word + "ct.scm", word + "cts/"})
+			So(complete(in, word, ",load "+word), ShouldResemble,
+				[]string{word + "ct.scm", word + "cts/"})
+			So(complete(in, word, `(display "`+word), ShouldBeEmpty)
+		})
+	})
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"bufio"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const defaultHistorySize = 1000
+
+// history keeps REPL input between sessions. Each entry is stored as one
+// line of the file, with newlines escaped, so multi-line forms are
+// recalled whole.
+type history struct {
+	path    string
+	max     int
+	entries []string
+}
+
+// defaultHistoryPath is $RSI_HISTORY, or ~/.rsi_history if that is unset.
+func defaultHistoryPath() string {
+	if p := os.Getenv("RSI_HISTORY"); p != "" {
+		return p
+	}
+	home := os.Getenv("HOME")
+	if home == "" {
+		return ""
+	}
+	return filepath.Join(home, ".rsi_history")
+}
+
+// loadHistory reads the history saved at path. A missing file is an empty
+// history; an empty path keeps history for this session only.
+func loadHistory(path string, max int) (*history, error) {
+	h := &history{path: path, max: max}
+	if path == "" {
+		return h, nil
+	}
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return h, nil
+	}
+	if err != nil {
+		return h, err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, 1024*1024)
+	for scanner.Scan() {
+		if line := scanner.Text(); line != "" {
+			h.entries = append(h.entries, unescapeHistory(line))
+		}
+	}
+	h.trim()
+	return h, scanner.Err()
+}
+
+// Add records entry, ignoring repeats of the previous one. It reports
+// whether the entry was added.
+func (h *history) Add(entry string) bool {
+	entry = strings.TrimSpace(entry)
+	if entry == "" || (len(h.entries) > 0 && h.entries[len(h.entries)-1] == entry) {
+		return false
+	}
+	h.entries = append(h.entries, entry)
+	h.trim()
+	return true
+}
+
+func (h *history) trim() {
+	if h.max > 0 && len(h.entries) > h.max {
+		h.entries = h.entries[len(h.entries)-h.max:]
+	}
+}
+
+// Save writes the history back to its file.
+func (h *history) Save() error {
+	if h.path == "" {
+		return nil
+	}
+	lines := make([]string, len(h.entries))
+	for i, e := range h.entries {
+		lines[i] = escapeHistory(e) + "\n"
+	}
+	return ioutil.WriteFile(h.path, []byte(strings.Join(lines, "")), 0600)
+}
+
+var (
+	historyEscaper   = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	historyUnescaper = strings.NewReplacer(`\\`, `\`, `\n`, "\n")
+)
+
+func escapeHistory(s string) string {
+	return historyEscaper.Replace(s)
+}
+
+func unescapeHistory(s string) string {
+	return historyUnescaper.Replace(s)
+}
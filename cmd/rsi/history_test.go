@@ -0,0 +1,55 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestHistory(t *testing.T) {
+	Convey("REPL history", t, func() {
+		dir, err := ioutil.TempDir("", "rsi")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+		path := filepath.Join(dir, "history")
+
+		Convey("starts empty without a file", func() {
+			h, err := loadHistory(path, 10)
+			So(err, ShouldBeNil)
+			So(h.entries, ShouldBeEmpty)
+		})
+
+		Convey("round trips multi-line entries", func() {
+			h, _ := loadHistory(path, 10)
+			So(h.Add("(define (f x)\n  (* x 2))"), ShouldBeTrue)
+			So(h.Add(`(f "a\nb")`), ShouldBeTrue)
+			So(h.Add(`(f "a\nb")`), ShouldBeFalse)
+			So(h.Add("  "), ShouldBeFalse)
+			So(h.Save(), ShouldBeNil)
+
+			h, err := loadHistory(path, 10)
+			So(err, ShouldBeNil)
+			So(h.entries, ShouldResemble, []string{"(define (f x)\n  (* x 2))", `(f "a\nb")`})
+		})
+
+		Convey("is capped", func() {
+			h, _ := loadHistory(path, 2)
+			h.Add("1")
+			h.Add("2")
+			h.Add("3")
+			So(h.entries, ShouldResemble, []string{"2", "3"})
+			So(h.Save(), ShouldBeNil)
+			h, _ = loadHistory(path, 1)
+			So(h.entries, ShouldResemble, []string{"3"})
+		})
+
+		Convey("defaults to the environment", func() {
+			defer os.Setenv("RSI_HISTORY", os.Getenv("RSI_HISTORY"))
+			os.Setenv("RSI_HISTORY", path)
+			So(defaultHistoryPath(), ShouldEqual, path)
+		})
+	})
+}
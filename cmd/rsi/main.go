@@ -0,0 +1,217 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/bobappleyard/readline"
+	"github.com/rread/rsi"
+	"github.com/rread/rsi/lexer"
+	"github.com/rread/rsi/log"
+)
+
+type inputStatus int
+
+const (
+	inputEmpty inputStatus = iota
+	inputComplete
+	inputIncomplete
+	inputError
+)
+
+// scanInput tokenizes s to decide whether it holds complete forms ready
+// to evaluate, or needs more lines. Malformed input is reported as an
+// error so it is submitted and the reader can explain the problem.
+// depth is the number of lists left open at the end of s.
+func scanInput(s string) (status inputStatus, depth int) {
+	l := lexer.New("input", s)
+	pending := false // a quote, #; or #n= is waiting for its datum
+	empty := true
+	for {
+		t := l.NextItem()
+		if t == nil {
+			break
+		}
+		switch t.Token {
+		case lexer.EOF:
+			switch {
+			case depth > 0 || pending:
+				return inputIncomplete, depth
+			case empty:
+				return inputEmpty, 0
+			}
+			return inputComplete, 0
+		case lexer.ILLEGAL:
+			if t.Incomplete {
+				return inputIncomplete, depth
+			}
+			return inputError, depth
+		case lexer.COMMENT, lexer.DIRECTIVE:
+			continue
+		case lexer.LEFT_PAREN, lexer.VECTOR:
+			depth++
+		case lexer.RIGHT_PAREN:
+			depth--
+			if depth < 0 {
+				return inputError, 0
+			}
+		case lexer.QUOTE, lexer.DATUM_COMMENT, lexer.DATUM_LABEL:
+			pending = true
+			empty = false
+			continue
+		}
+		pending = false
+		empty = false
+	}
+	return inputComplete, depth
+}
+
+// replCLI reads and evaluates forms until the input ends, returning the
+// status given to exit if the program calls it.
+func replCLI(s *session, hist *history) int {
+	defer fmt.Println("\nbye!")
+	readline.Completer = func(word, line string) []string {
+		return complete(s.in, word, line)
+	}
+	for _, entry := range hist.entries {
+		readline.AddHistory(entry)
+	}
+	counter := readline.HistorySize()
+	for !s.done {
+		buf := bytes.Buffer{}
+		first := fmt.Sprintf("[%d]-> ", counter)
+		prompt := first
+		for {
+			l, err := readline.String(prompt)
+			if err == io.EOF {
+				return 0
+			}
+			buf.WriteString(l)
+			if isCommand(buf.String()) {
+				break
+			}
+			status, depth := scanInput(buf.String())
+			if status == inputComplete || status == inputError {
+				break
+			}
+			buf.WriteString("\n")
+			prompt = continuationPrompt(len(first), depth)
+		}
+		if strings.TrimSpace(buf.String()) == "" {
+			continue
+		}
+		if isCommand(buf.String()) {
+			if err := runCommand(s, buf.String()); err != nil {
+				printError(os.Stdout, err)
+			}
+		} else {
+			result, err := s.in.Eval(buf.String())
+			if e, ok := err.(*rsi.ExitError); ok {
+				return e.Code
+			} else if err != nil && err != rsi.ErrorEOF {
+				printError(os.Stdout, err)
+			} else {
+				fmt.Println(result)
+			}
+		}
+		if hist.Add(buf.String()) {
+			readline.AddHistory(strings.TrimSpace(buf.String()))
+			if err := hist.Save(); err != nil {
+				log.Errorln("saving history:", err)
+			}
+		}
+		counter++
+
+	}
+	return 0
+}
+
+// continuationPrompt shows how many lists are open, right aligned to
+// width.
+func continuationPrompt(width, depth int) string {
+	p := fmt.Sprintf("%d> ", depth)
+	if len(p) < width {
+		p = strings.Repeat(".", width-len(p)) + p
+	}
+	return p
+}
+
+func printError(w io.Writer, err error) {
+	fmt.Fprintln(w, "Error:", err)
+	if e, ok := err.(*rsi.EvalError); ok {
+		fmt.Fprint(w, e.Backtrace())
+	}
+}
+
+func main() {
+	debug := flag.Bool("debug", false, "Enable debugging")
+	histPath := flag.String("history", defaultHistoryPath(),
+		"REPL history file, empty to disable; $RSI_HISTORY sets the default")
+	histSize := flag.Int("history-size", defaultHistorySize, "Maximum number of history entries kept")
+	dialectName := flag.String("dialect", "legacy", "Language dialect: legacy (upper case symbols) or r7rs (case sensitive)")
+	engineName := flag.String("engine", "analyzer", "Evaluation engine: analyzer or vm (bytecode)")
+	strict := flag.Bool("strict", false, "Warn when a program redefines a builtin")
+	interactive := flag.Bool("i", false, "Enter the REPL after running the program")
+	var exprs exprList
+	flag.Var(&exprs, "e", "Evaluate `expr`, may be repeated")
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: %s [flags] [script.scm | -] [args...]\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	dialect, err := rsi.ParseDialect(*dialectName)
+	if err != nil {
+		log.Fatal(err)
+	}
+	engine, err := rsi.ParseEngine(*engineName)
+	if err != nil {
+		log.Fatal(err)
+	}
+	opts := []rsi.Option{rsi.WithDialect(dialect), rsi.WithEngine(engine)}
+	if *strict {
+		opts = append(opts, rsi.WithStrict())
+	}
+	if *debug {
+		opts = append(opts, rsi.WithLogLevel(log.Debug))
+	}
+	s := newSession(os.Stdout, opts...)
+	in := s.in
+
+	args := flag.Args()
+	if len(args) == 0 {
+		bindCommandLine(in, os.Args[:1])
+	} else {
+		bindCommandLine(in, args)
+	}
+
+	status := 0
+	for _, expr := range exprs {
+		_, err := in.EvalNamed("-e", strings.NewReader(expr))
+		if code, exit := exitStatus(os.Stderr, err); exit {
+			os.Exit(code)
+		} else if code != 0 {
+			status = code
+			break
+		}
+	}
+	if status == 0 && len(args) > 0 {
+		code, exit := exitStatus(os.Stderr, runScript(args[0], in))
+		if exit {
+			os.Exit(code)
+		}
+		status = code
+	}
+
+	if *interactive || (len(exprs) == 0 && len(args) == 0) {
+		hist, err := loadHistory(*histPath, *histSize)
+		if err != nil {
+			log.Errorln("loading history:", err)
+		}
+		status = replCLI(s, hist)
+	}
+	os.Exit(status)
+}
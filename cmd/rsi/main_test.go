@@ -0,0 +1,42 @@
+package main
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestInputStatus(t *testing.T) {
+	Convey("the REPL waits for complete forms", t, func() {
+		for _, c := range []struct {
+			input  string
+			status inputStatus
+			depth  int
+		}{
+			{"", inputEmpty, 0},
+			{"  ; just a comment", inputEmpty, 0},
+			{"(+ 1 2)", inputComplete, 0},
+			{"1 2", inputComplete, 0},
+			{"(define (f x)", inputIncomplete, 1},
+			{"(list \"(\" ", inputIncomplete, 1},
+			{"(list \")\")", inputComplete, 0},
+			{"(f ; )\n", inputIncomplete, 1},
+			{"(f \"open", inputIncomplete, 1},
+			{"'", inputIncomplete, 0},
+			{"'(a (b", inputIncomplete, 2},
+			{"#| (", inputIncomplete, 0},
+			{"(a #|)|#", inputIncomplete, 1},
+			{"(a #;", inputIncomplete, 1},
+			{"#;(a) b", inputComplete, 0},
+			{"#0=", inputIncomplete, 0},
+			{"#0=(a . #0#)", inputComplete, 0},
+			{"(a))", inputError, 0},
+			{"(a #n", inputError, 1},
+		} {
+			status, depth := scanInput(c.input)
+			So(status, ShouldEqual, c.status)
+			So(depth, ShouldEqual, c.depth)
+		}
+		So(continuationPrompt(7, 2), ShouldEqual, "....2> ")
+	})
+}
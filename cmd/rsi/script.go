@@ -0,0 +1,57 @@
+package main
+
+import (
+	"io"
+	"os"
+	"strings"
+
+	"github.com/rread/rsi"
+)
+
+// exprList collects the expressions given by repeated -e flags.
+type exprList []string
+
+func (l *exprList) String() string {
+	return strings.Join(*l, " ")
+}
+
+func (l *exprList) Set(s string) error {
+	*l = append(*l, s)
+	return nil
+}
+
+// bindCommandLine makes args available to the program as (command-line).
+func bindCommandLine(in *rsi.Interpreter, args []string) {
+	items := make([]rsi.Data, len(args))
+	for i, arg := range args {
+		items[i] = rsi.String(arg)
+	}
+	list := rsi.List(items...)
+	in.Define("command-line", rsi.NewBuiltin(0, 0, func(rsi.Data) (rsi.Data, error) {
+		return list, nil
+	}))
+}
+
+// runScript evaluates the program in the named file, or standard input
+// if name is "-".
+func runScript(name string, in *rsi.Interpreter) error {
+	if name == "-" {
+		_, err := in.EvalNamed("stdin", os.Stdin)
+		return err
+	}
+	_, err := in.Load(name)
+	return err
+}
+
+// exitStatus reports err on w and returns the status the process should
+// exit with. exit is set if the program asked to stop.
+func exitStatus(w io.Writer, err error) (status int, exit bool) {
+	if err == nil {
+		return 0, false
+	}
+	if e, ok := err.(*rsi.ExitError); ok {
+		return e.Code, true
+	}
+	printError(w, err)
+	return 1, false
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/rread/rsi"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestScript(t *testing.T) {
+	Convey("running scripts", t, func() {
+		var out bytes.Buffer
+		in := rsi.New()
+		in.Stdout = &out
+		bindCommandLine(in, []string{"prog.scm", "a", "b"})
+
+		dir, err := ioutil.TempDir("", "rsi")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+		script := func(src string) string {
+			name := filepath.Join(dir, "prog.scm")
+			So(ioutil.WriteFile(name, []byte(src), 0644), ShouldBeNil)
+			return name
+		}
+
+		Convey("see their arguments and write output", func() {
+			name := script("#!/usr/bin/env rsi\n(display (cdr (command-line)))\n(newline)\n(display \"done\")")
+			So(runScript(name, in), ShouldBeNil)
+			So(out.String(), ShouldEqual, "(\"a\" \"b\")\ndone")
+		})
+
+		Convey("are read in full", func() {
+			src := strings.Repeat("; padding\n", 200) + "(display 'end)"
+			So(runScript(script(src), in), ShouldBeNil)
+			So(out.String(), ShouldEqual, "END")
+		})
+
+		Convey("report errors with a failing status", func() {
+			err := runScript(script("(define (f) (car 1))\n(f)"), in)
+			var msg bytes.Buffer
+			status, exit := exitStatus(&msg, err)
+			So(status, ShouldEqual, 1)
+			So(exit, ShouldBeFalse)
+			So(msg.String(), ShouldStartWith, "Error: car received: 1: value is not a pair\n")
+			So(msg.String(), ShouldContainSubstring, "prog.scm:1:13")
+		})
+
+		Convey("set the exit status", func() {
+			for _, c := range []struct {
+				src    string
+				status int
+			}{
+				{"(exit)", 0},
+				{"(exit 3)", 3},
+				{"(define (f) (exit 4)) (f) (display 'unreached)", 4},
+				{"(exit #f)", 1},
+				{"(exit #t)", 0},
+			} {
+				status, exit := exitStatus(ioutil.Discard, runScript(script(c.src), in))
+				So(exit, ShouldBeTrue)
+				So(status, ShouldEqual, c.status)
+			}
+			So(out.String(), ShouldEqual, "")
+		})
+	})
+}
@@ -0,0 +1,383 @@
+package rsi
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// opcode is an instruction of the VM. The comments describe what each
+// does with the instruction's argument.
+type opcode uint8
+
+const (
+	opConst        opcode = iota // push constant arg
+	opGlobal                     // push the global in the cell in constant arg
+	opLocal                      // push the variable in slot arg of frame depth
+	opDefineGlobal               // bind the global in the cell in constant arg to the top value
+	opDefineLocal                // bind slot arg of the current frame to the top value
+	opSetGlobal                  // assign the top value to the global in constant arg
+	opSetLocal                   // assign the top value to slot arg of frame depth
+	opPop                        // discard the top value
+	opJump                       // continue at instruction arg
+	opJumpFalse                  // pop a value and continue at arg if it is false
+	opClosure                    // push a procedure for the code in constant arg
+	opCall                       // apply a procedure to arg arguments
+	opTailCall                   // apply a procedure, replacing the current frame
+	opReturn                     // return the top value to the caller
+	opError                      // fail with the error in constant arg
+	opQuit                       // stop the program
+)
+
+var opNames = [...]string{
+	opConst:        "const",
+	opGlobal:       "global",
+	opLocal:        "local",
+	opDefineGlobal: "def-global",
+	opDefineLocal:  "def-local",
+	opSetGlobal:    "set-global",
+	opSetLocal:     "set-local",
+	opPop:          "pop",
+	opJump:         "jump",
+	opJumpFalse:    "jump-false",
+	opClosure:      "closure",
+	opCall:         "call",
+	opTailCall:     "tail-call",
+	opReturn:       "return",
+	opError:        "error",
+	opQuit:         "quit",
+}
+
+func (op opcode) String() string {
+	return opNames[op]
+}
+
+type instr struct {
+	op    opcode
+	depth uint16 // of the frame of a local variable
+	arg   int32
+}
+
+// maxDepth is how deeply lambdas can be nested.
+const maxDepth = 1<<16 - 1
+
+// code is the compiled body of a procedure, or of a top level form.
+type code struct {
+	params []Symbol
+	frame  []Symbol // the slots of the frame of a call
+	scope  *scope   // where the code runs, to name local variables
+	instrs []instr
+	consts []Data
+	calls  []*Pair // the expression of each call, by instruction
+}
+
+// compiler translates expressions into the instructions of one code
+// object.
+type compiler struct {
+	in   *Interpreter
+	code *code
+}
+
+// compile translates expr into code, to run in a frame of scope sc, that
+// returns its value.
+func compile(expr Data, sc *scope, in *Interpreter) (*code, error) {
+	c := &compiler{in: in, code: &code{scope: sc}}
+	if err := c.compile(expr, true); err != nil {
+		return nil, err
+	}
+	return c.code, nil
+}
+
+func (c *compiler) emit(op opcode, arg int) int {
+	c.code.instrs = append(c.code.instrs, instr{op: op, arg: int32(arg)})
+	return len(c.code.instrs) - 1
+}
+
+// variable emits op for the local variable sym, or global if it is not
+// in scope.
+func (c *compiler) variable(sym Symbol, local, global opcode) error {
+	depth, i, ok := c.code.scope.lookup(sym)
+	if !ok {
+		c.emit(global, c.constant(c.in.env.cell(sym)))
+		return nil
+	}
+	if depth > maxDepth {
+		return fmt.Errorf("%v: lambdas nested too deeply", sym)
+	}
+	c.code.instrs = append(c.code.instrs, instr{op: local, depth: uint16(depth), arg: int32(i)})
+	return nil
+}
+
+// patch makes the jump at pc continue at the next instruction emitted.
+func (c *compiler) patch(pc int) {
+	c.code.instrs[pc].arg = int32(len(c.code.instrs))
+}
+
+// constant returns the index of d among the constants, symbols are
+// only stored once.
+func (c *compiler) constant(d Data) int {
+	if s, ok := d.(Symbol); ok {
+		for i, k := range c.code.consts {
+			if k == s {
+				return i
+			}
+		}
+	}
+	c.code.consts = append(c.code.consts, d)
+	return len(c.code.consts) - 1
+}
+
+// compile emits the instructions for expr. In tail position its value is
+// returned from the code, otherwise it is left on the stack.
+func (c *compiler) compile(expr Data, tail bool) error {
+	switch e := expr.(type) {
+	case Boolean, Number, String, Null, *Vector:
+		c.emit(opConst, c.constant(e))
+	case Symbol:
+		if err := c.variable(e, opLocal, opGlobal); err != nil {
+			return err
+		}
+	case *Pair:
+		s, _ := getSymbol(e.car)
+		/* non-Symbols fall through to default */
+		switch c.in.syms.forms[s] {
+		case formQuote:
+			c.emit(opConst, c.constant(cadr(e)))
+		case formDefine:
+			if err := c.define(e); err != nil {
+				return err
+			}
+		case formSet:
+			name, err := getSymbol(cadr(e))
+			if err != nil {
+				return err
+			}
+			if err := c.compile(caddr(e), false); err != nil {
+				return err
+			}
+			if err := c.variable(name, opSetLocal, opSetGlobal); err != nil {
+				return err
+			}
+		case formIf:
+			return c.conditional(e, tail)
+		case formLet:
+			x, err := expandLet(e.cdr, c.in.syms)
+			if err != nil {
+				return err
+			}
+			return c.compile(x, tail)
+		case formLetrec:
+			x, err := expandLetrec(e.cdr, c.in.syms)
+			if err != nil {
+				return err
+			}
+			return c.compile(x, tail)
+		case formBegin:
+			return c.sequence(e.cdr, tail)
+		case formQuit:
+			c.emit(opQuit, 0)
+			return nil
+		case formLambda:
+			if err := c.lambda(cadr(e), cddr(e)); err != nil {
+				return err
+			}
+		default:
+			return c.application(e, tail)
+		}
+	case nil:
+		return errors.New("cannot evaluate a missing expression")
+	default:
+		return fmt.Errorf("Unparsable expression: %v", expr)
+	}
+	if tail {
+		c.emit(opReturn, 0)
+	}
+	return nil
+}
+
+func (c *compiler) define(e *Pair) error {
+	defn, err := getPair(e.cdr)
+	if err != nil {
+		return err
+	}
+	var name Symbol
+	switch target := defn.car.(type) {
+	// (define var value)
+	case Symbol:
+		name = target
+		err = c.compile(cadr(defn), false)
+	// (define (proc a b) (body))
+	case *Pair:
+		name, err = getSymbol(target.car)
+		if err != nil {
+			return err
+		}
+		err = c.lambda(target.cdr, defn.cdr)
+	default:
+		return fmt.Errorf("cannot define %v", target)
+	}
+	if err != nil {
+		return err
+	}
+	sc := c.code.scope
+	if sc == nil {
+		c.emit(opDefineGlobal, c.constant(c.in.env.cell(name)))
+		return nil
+	}
+	if err := checkDefine(name, sc); err != nil {
+		return err
+	}
+	c.emit(opDefineLocal, indexOf(sc.names, name))
+	return nil
+}
+
+func (c *compiler) conditional(e *Pair, tail bool) error {
+	if err := c.compile(cadr(e), false); err != nil {
+		return err
+	}
+	alternative := c.emit(opJumpFalse, 0)
+	if err := c.compile(caddr(e), tail); err != nil {
+		return err
+	}
+	end := -1
+	if !tail {
+		end = c.emit(opJump, 0)
+	}
+	c.patch(alternative)
+	if listLen(e) > 3 {
+		if err := c.compile(cadddr(e), tail); err != nil {
+			return err
+		}
+	} else if err := c.compile(Empty, tail); err != nil {
+		return err
+	}
+	if end >= 0 {
+		c.patch(end)
+	}
+	return nil
+}
+
+// sequence compiles the non-empty list of expressions body, leaving only
+// the value of the last.
+func (c *compiler) sequence(body Data, tail bool) error {
+	if _, err := getPair(body); err != nil {
+		return err
+	}
+	items, err := listSlice(body)
+	if err != nil {
+		return err
+	}
+	for i, item := range items {
+		last := i == len(items)-1
+		if err := c.compile(item, tail && last); err != nil {
+			return err
+		}
+		if !last {
+			c.emit(opPop, 0)
+		}
+	}
+	return nil
+}
+
+func (c *compiler) lambda(params Data, body Data) error {
+	names, err := lambdaParams(params, body)
+	if err != nil {
+		return err
+	}
+	frame := frameNames(names, body, c.in.syms)
+	sub := &compiler{in: c.in, code: &code{
+		params: names,
+		frame:  frame,
+		scope:  &scope{names: frame, outer: c.code.scope},
+	}}
+	if nullp(body) {
+		// an empty body is only an error if the procedure is called
+		_, err := getPair(body)
+		sub.emit(opError, sub.constant(err))
+	} else if err := sub.sequence(body, true); err != nil {
+		return err
+	}
+	c.emit(opClosure, c.constant(sub.code))
+	return nil
+}
+
+func (c *compiler) application(e *Pair, tail bool) error {
+	if err := c.compile(e.car, false); err != nil {
+		return err
+	}
+	items, err := listSlice(e.cdr)
+	if err != nil {
+		return err
+	}
+	for _, item := range items {
+		if err := c.compile(item, false); err != nil {
+			return err
+		}
+	}
+	op := opCall
+	if tail {
+		op = opTailCall
+	}
+	pc := c.emit(op, len(items))
+	for len(c.code.calls) < pc {
+		c.code.calls = append(c.code.calls, nil)
+	}
+	c.code.calls = append(c.code.calls, e)
+	return nil
+}
+
+// disassemble writes a listing of the instructions of c headed by
+// title, followed by those of the procedures it creates.
+func (c *code) disassemble(w io.Writer, title string) {
+	fmt.Fprintf(w, "%s\n", title)
+	var nested []*code
+	for pc, i := range c.instrs {
+		var arg interface{}
+		switch i.op {
+		case opConst, opError:
+			arg = c.consts[i.arg]
+		case opGlobal, opDefineGlobal, opSetGlobal:
+			arg = c.consts[i.arg].(*cell).name
+		case opLocal, opSetLocal:
+			arg = fmt.Sprintf("%d %d ; %v", i.depth, i.arg, c.scope.name(int(i.depth), int(i.arg)))
+		case opDefineLocal:
+			arg = fmt.Sprintf("%d ; %v", i.arg, c.scope.name(0, int(i.arg)))
+		case opClosure:
+			k := c.consts[i.arg].(*code)
+			nested = append(nested, k)
+			arg = k.signature()
+		case opJump, opJumpFalse, opCall, opTailCall:
+			arg = i.arg
+		}
+		if arg == nil {
+			fmt.Fprintf(w, "%4d  %s\n", pc, i.op)
+		} else {
+			fmt.Fprintf(w, "%4d  %-10s %v\n", pc, i.op, arg)
+		}
+	}
+	for _, k := range nested {
+		k.disassemble(w, k.signature())
+	}
+}
+
+func (c *code) signature() string {
+	params := make([]string, len(c.params))
+	for i, p := range c.params {
+		params[i] = string(p)
+	}
+	return fmt.Sprintf("(lambda (%s) ...)", strings.Join(params, " "))
+}
+
+// bindDisassemble binds (disassemble proc), which lists the
+// instructions of a procedure compiled for the VM.
+func bindDisassemble(env *Env) {
+	env.BindName("disassemble", Apply1(func(a Data) (Data, error) {
+		l, ok := a.(*Lambda)
+		if !ok || l.code == nil {
+			return nil, fmt.Errorf("disassemble: not a compiled procedure: %v", a)
+		}
+		l.code.disassemble(env.interp.Stdout, l.String())
+		return env.interp.syms.ok, nil
+	}))
+}
@@ -1,72 +1,170 @@
-package main
+package rsi
 
-import "fmt"
+import (
+	"fmt"
+	"sort"
+)
 
-type Binding map[Symbol]Data
+// Env is an environment of variables. The top level environment maps
+// names to cells. Each procedure call has a frame nested in the
+// environment of its procedure, holding its variables in slots that
+// are found by position, as resolved when the procedure was analyzed.
 type Env struct {
-	vars  Binding
-	outer *Env
+	vars   map[Symbol]*cell // top level only
+	names  []Symbol         // the variables of a frame
+	slots  []Data           // and their values, nil until defined
+	outer  *Env
+	interp *Interpreter
 }
 
-func NewEnv(outer *Env) *Env {
-	return &Env{
-		vars:  make(Binding),
-		outer: outer,
+// cell holds a global variable. Code refers to the cell of a global
+// directly, so it is created unbound when it is first referred to.
+type cell struct {
+	name    Symbol
+	value   Data
+	bound   bool
+	builtin bool // bound by NewDefaultEnv, and not since redefined
+}
+
+func (c *cell) get() (Data, error) {
+	if !c.bound {
+		return nil, fmt.Errorf("Undefined symbol: %v", c.name)
 	}
+	return c.value, nil
+}
 
+func (c *cell) set(v Data) {
+	c.value = v
+	c.bound = true
 }
 
-func ExtendEnv(names []Symbol, values Data, outer *Env) (*Env, error) {
-	env := NewEnv(outer)
-	if len(names) != listLen(values) {
-		return nil, fmt.Errorf("parameter mismatch %v != %v", names, values)
+// assign sets the value of a global that must already be bound.
+func (c *cell) assign(v Data) error {
+	if !c.bound {
+		return unbound(c.name)
 	}
-	for _, name := range names {
+	c.value = v
+	return nil
+}
+
+func unbound(sym Symbol) error {
+	return fmt.Errorf("set!: unbound variable %v", sym)
+}
 
-		val := car(values)
+// NewEnv returns an empty frame nested in outer, belonging to the same
+// interpreter. A nil outer gives a new Legacy top level environment.
+func NewEnv(outer *Env) *Env {
+	if outer == nil {
+		return NewTopEnv(Legacy)
+	}
+	return &Env{
+		outer:  outer,
+		interp: outer.interp,
+	}
 
-		if err := getError(val); err != nil {
-			return nil, err
-		}
-		env.Bind(name, val)
+}
 
-		values = cdr(values)
-		if err := getError(val); err != nil {
-			return nil, err
-		}
+// Intern returns the symbol for name in this environment's dialect.
+func (e *Env) Intern(name string) Symbol {
+	return e.interp.syms.Intern(name)
+}
 
+// ExtendEnv returns a frame nested in outer binding names to the list
+// of values.
+func ExtendEnv(names []Symbol, values Data, outer *Env) (*Env, error) {
+	slots, err := listSlice(values)
+	if err != nil {
+		return nil, err
+	}
+	if len(names) != len(slots) {
+		return nil, fmt.Errorf("parameter mismatch %v != %v", names, values)
 	}
-	return env, nil
+	return &Env{names: names, slots: slots, outer: outer, interp: outer.interp}, nil
 }
 
+// BindName binds a value to name, recording the name on builtins so
+// they can be identified when printed.
 func (e *Env) BindName(name string, i Data) {
-	sym := internSymbol(name)
-	e.vars[sym] = i
+	sym := e.Intern(name)
+	switch f := i.(type) {
+	case InternalFunc:
+		i = &Builtin{name: sym, fn: f, max: -1}
+	case *Builtin:
+		if f.name == "" {
+			f.name = sym
+		}
+	}
+	e.Bind(sym, i)
 }
 
 func (e *Env) Bind(sym Symbol, i Data) {
 	if !Symbolp(sym) {
 		panic(fmt.Errorf("sym is not a Symbol: %v", sym))
 	}
-	e.vars[sym] = i
+	if e.vars != nil {
+		e.cell(sym).set(i)
+		return
+	}
+	if n := e.slot(sym); n >= 0 {
+		e.slots[n] = i
+		return
+	}
+	// the names may be shared with other frames of the same procedure
+	e.names = append(e.names[:len(e.names):len(e.names)], sym)
+	e.slots = append(e.slots, i)
 }
 
 func (e *Env) Var(sym Symbol) (Data, error) {
 	if !Symbolp(sym) {
 		panic(fmt.Errorf("sym is not a Symbol: %v", sym))
 	}
-	v, ok := e.vars[sym]
+	if e.vars != nil {
+		if c, ok := e.vars[sym]; ok {
+			return c.get()
+		}
+	} else if n := e.slot(sym); n >= 0 && e.slots[n] != nil {
+		return e.slots[n], nil
+	}
+	return nil, fmt.Errorf("Undefined symbol: %v", sym)
+}
+
+// cell returns the cell of the global sym, creating it if need be.
+func (e *Env) cell(sym Symbol) *cell {
+	c, ok := e.vars[sym]
 	if !ok {
-		return nil, fmt.Errorf("Undefined symbol: %v", sym)
+		c = &cell{name: sym}
+		e.vars[sym] = c
+	}
+	return c
+}
+
+// slot returns the position of sym in a frame, or -1.
+func (e *Env) slot(sym Symbol) int {
+	return indexOf(e.names, sym)
+}
+
+func indexOf(names []Symbol, sym Symbol) int {
+	for i, name := range names {
+		if name == sym {
+			return i
+		}
 	}
-	return v, nil
+	return -1
+}
+
+func (e *Env) defines(sym Symbol) bool {
+	if e.vars != nil {
+		c, ok := e.vars[sym]
+		return ok && c.bound
+	}
+	return e.slot(sym) >= 0
 }
 
 func (env *Env) Find(sym Symbol) *Env {
 	if !Symbolp(sym) {
 		panic(fmt.Errorf("sym is not a Symbol: %v", sym))
 	}
-	if _, ok := env.vars[sym]; ok {
+	if env.defines(sym) {
 		return env
 	} else if env.outer != nil {
 		return env.outer.Find(sym)
@@ -78,3 +176,113 @@ func (env *Env) Find(sym Symbol) *Env {
 func (env *Env) FindVar(sym Symbol) (Data, error) {
 	return env.Find(sym).Var(sym)
 }
+
+// Names returns the symbols bound directly in e in sorted order.
+func (e *Env) Names() []Symbol {
+	var names []Symbol
+	if e.vars != nil {
+		for k, c := range e.vars {
+			if c.bound {
+				names = append(names, k)
+			}
+		}
+	} else {
+		for i, k := range e.names {
+			if e.slots[i] != nil {
+				names = append(names, k)
+			}
+		}
+	}
+	sort.Slice(names, func(i, j int) bool { return names[i] < names[j] })
+	return names
+}
+
+// local returns the value in slot i of a frame.
+func (e *Env) local(i int) (Data, error) {
+	if v := e.slots[i]; v != nil {
+		return v, nil
+	}
+	return nil, fmt.Errorf("Undefined symbol: %v", e.names[i])
+}
+
+// assign sets slot i of a frame, which must already be bound.
+func (e *Env) assign(i int, v Data) error {
+	if e.slots[i] == nil {
+		return unbound(e.names[i])
+	}
+	e.slots[i] = v
+	return nil
+}
+
+// frame returns the frame depth levels out from e.
+func (e *Env) frame(depth int) *Env {
+	for ; depth > 0; depth-- {
+		e = e.outer
+	}
+	return e
+}
+
+// scope is the static counterpart of a frame: the names of its slots,
+// known when a procedure is analyzed.
+type scope struct {
+	names []Symbol
+	outer *scope
+}
+
+// scopeOf returns the scope of the frames of env, nil at the top level.
+func scopeOf(env *Env) *scope {
+	if env.vars != nil {
+		return nil
+	}
+	return &scope{names: env.names, outer: scopeOf(env.outer)}
+}
+
+// lookup returns the lexical address of sym, which is a global if ok
+// is false.
+func (s *scope) lookup(sym Symbol) (depth, index int, ok bool) {
+	for ; s != nil; s = s.outer {
+		if i := indexOf(s.names, sym); i >= 0 {
+			return depth, i, true
+		}
+		depth++
+	}
+	return 0, 0, false
+}
+
+// name returns the name of a lexical address.
+func (s *scope) name(depth, index int) Symbol {
+	for ; depth > 0; depth-- {
+		s = s.outer
+	}
+	return s.names[index]
+}
+
+// frameNames returns the slots of the frame of a procedure: its
+// params, then the names defined in its body.
+func frameNames(params []Symbol, body Data, syms *SymbolTable) []Symbol {
+	names := append([]Symbol(nil), params...)
+	var scan func(body Data)
+	scan = func(body Data) {
+		for ; pairp(body); body = cdr(body) {
+			form, ok := car(body).(*Pair)
+			if !ok {
+				continue
+			}
+			c, _ := getSymbol(form.car)
+			switch syms.forms[c] {
+			case formBegin:
+				scan(form.cdr)
+			case formDefine:
+				name, _ := getSymbol(cadr(form))
+				if p, ok := cadr(form).(*Pair); ok {
+					name, _ = getSymbol(p.car)
+				}
+				if name != "" && indexOf(names, name) < 0 {
+					names = append(names, name)
+				}
+			}
+		}
+	}
+	scan(body)
+	return names
+}
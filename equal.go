@@ -0,0 +1,120 @@
+package rsi
+
+import (
+	"math"
+	"reflect"
+)
+
+// eqv reports whether a and b are the same object. Pairs, vectors, hash
+// tables, procedures and Go objects are compared by identity, numbers
+// by value, so that 0 and -0 differ and a NaN is the same as itself.
+// Strings are Go values with no identity of their own, so they are
+// compared by their contents. There are no objects that eq? could tell
+// apart and eqv? could not, so eq? is eqv.
+func eqv(a, b Data) bool {
+	switch x := a.(type) {
+	case Number:
+		y, ok := b.(Number)
+		return ok && math.Float64bits(float64(x)) == math.Float64bits(float64(y))
+	case InternalFunc:
+		y, ok := b.(InternalFunc)
+		return ok && reflect.ValueOf(x).Pointer() == reflect.ValueOf(y).Pointer()
+	}
+	if a == nil || b == nil || !reflect.TypeOf(a).Comparable() {
+		return a == nil && b == nil
+	}
+	return a == b
+}
+
+// equal reports whether a and b print the same: pairs and vectors are
+// compared by their contents, everything else with eqv. Cyclic
+// structure is handled by assuming two objects equal while they are
+// being compared, so comparing them again ends the walk.
+func equal(a, b Data) bool {
+	return (&equality{}).equal(a, b)
+}
+
+// equal is equal? for a running program, which can be cancelled while
+// it compares large structures.
+func (in *Interpreter) equal(a, b Data) (bool, error) {
+	e := &equality{poll: in.poll}
+	same := e.equal(a, b)
+	return same, e.err
+}
+
+type equality struct {
+	assumed map[[2]Data]bool
+	poll    func() error // if set, called for each object compared
+	err     error        // from poll, which ends the comparison
+}
+
+// assume records that a and b are being compared, reporting whether
+// they already were.
+func (e *equality) assume(a, b Data) bool {
+	if e.assumed == nil {
+		e.assumed = make(map[[2]Data]bool)
+	}
+	k := [2]Data{a, b}
+	if e.assumed[k] {
+		return true
+	}
+	e.assumed[k] = true
+	return false
+}
+
+func (e *equality) equal(a, b Data) bool {
+	for {
+		if e.poll != nil {
+			if e.err = e.poll(); e.err != nil {
+				return false
+			}
+		}
+		switch x := a.(type) {
+		case *Pair:
+			y, ok := b.(*Pair)
+			if !ok {
+				return false
+			}
+			if x == y || e.assume(x, y) {
+				return true
+			}
+			if !e.equal(x.car, y.car) {
+				return false
+			}
+			// follow the cdrs in the loop, lists can be long
+			a, b = x.cdr, y.cdr
+			continue
+		case *Vector:
+			y, ok := b.(*Vector)
+			if !ok || len(x.items) != len(y.items) {
+				return false
+			}
+			if x == y || e.assume(x, y) {
+				return true
+			}
+			for i := range x.items {
+				if !e.equal(x.items[i], y.items[i]) {
+					return false
+				}
+			}
+			return true
+		}
+		return eqv(a, b)
+	}
+}
+
+func bindEquivalence(env *Env) {
+	env.BindName("eq?", Apply2(func(a, b Data) (Data, error) {
+		return Boolean(eqv(a, b)), nil
+	}))
+	env.BindName("eqv?", Apply2(func(a, b Data) (Data, error) {
+		return Boolean(eqv(a, b)), nil
+	}))
+	env.BindName("equal?", Apply2(func(a, b Data) (Data, error) {
+		same, err := env.interp.equal(a, b)
+		if err != nil {
+			return nil, err
+		}
+		return Boolean(same), nil
+	}))
+}
@@ -0,0 +1,38 @@
+package rsi
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// cycle returns a circular list of items.
+func cycle(items ...Data) *Pair {
+	l := List(items...).(*Pair)
+	last := l
+	for pairp(last.cdr) {
+		last = last.cdr.(*Pair)
+	}
+	last.cdr = l
+	return l
+}
+
+func TestEquivalence(t *testing.T) {
+	Convey("equal? terminates on cyclic structure", t, func() {
+		So(equal(cycle(Number(1), Number(2)), cycle(Number(1), Number(2))), ShouldBeTrue)
+		So(equal(cycle(Number(1), Number(2)), cycle(Number(1), Number(2), Number(1), Number(2))), ShouldBeTrue)
+		So(equal(cycle(Number(1), Number(2)), cycle(Number(1), Number(3))), ShouldBeFalse)
+		So(equal(cycle(Number(1)), List(Number(1), Number(1))), ShouldBeFalse)
+
+		v, w := NewVector(Number(1), nil), NewVector(Number(1), nil)
+		v.items[1], w.items[1] = v, w
+		So(equal(v, w), ShouldBeTrue)
+		So(eqv(v, w), ShouldBeFalse)
+	})
+
+	Convey("eqv? compares Go procedures by identity", t, func() {
+		So(eqv(InternalFunc(_car), InternalFunc(_car)), ShouldBeTrue)
+		So(eqv(InternalFunc(_car), InternalFunc(_cdr)), ShouldBeFalse)
+		So(eqv(NewForeign(1), NewForeign(1)), ShouldBeFalse)
+	})
+}
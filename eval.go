@@ -1,12 +1,12 @@
-package main
+package rsi
 
 import (
 	"errors"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"math"
 	"os"
-	"reflect"
 	"strconv"
 	"strings"
 
@@ -35,7 +35,7 @@ func (num Number) String() string {
 }
 
 func (fun InternalFunc) String() string {
-	return fmt.Sprintf("native-func '%v'", ((func(Data) (Data, error))(fun)))
+	return "#<procedure>"
 }
 
 func (b Boolean) String() string {
@@ -76,30 +76,55 @@ var (
 
 var ErrorEOF = errors.New("End of File")
 
-func read(l Tokenizer) (Data, error) {
-	t := l.NextItem()
+// reader parses data from a stream of tokens, interning symbols in syms.
+type reader struct {
+	lex       Tokenizer
+	syms      *SymbolTable
+	log       *log.Logger
+	maxString int
+	labels    map[string]Data // the datum labels of the current datum
+}
+
+func newReader(l Tokenizer, in *Interpreter) *reader {
+	return &reader{lex: l, syms: in.syms, log: in.log, maxString: in.limits.StringSize}
+}
+
+// readDatum reads a top level datum, which is the scope of its datum
+// labels.
+func (r *reader) readDatum() (Data, error) {
+	r.labels = nil
+	return r.read()
+}
+
+func (r *reader) read() (Data, error) {
+	t := r.lex.NextItem()
 	if t == nil {
 		return nil, ErrorEOF
 	}
 	//log.Debugf("scan: %v\n", t)
 	switch t.Token {
 	case lexer.LEFT_PAREN:
-		return readList(l)
+		return r.readList(t.Pos)
+	case lexer.VECTOR:
+		return r.readVector()
 	case lexer.RIGHT_PAREN:
 		return nil, nil
 	case lexer.SYMBOL:
-		return internSymbol(t.Lit), nil
+		return r.syms.Intern(t.Lit), nil
 	case lexer.QUOTE:
-		return readQuote(l)
+		return r.readQuote(t.Pos)
 	case lexer.NUMBER:
 		v, err := strconv.ParseFloat(t.Lit, 64)
 		if err != nil {
-			log.Fatal("Number fail:", err)
+			return nil, fmt.Errorf("%v: bad number %q", t.Pos, t.Lit)
 		}
 		return Number(v), nil
 	case lexer.EOF:
 		return nil, ErrorEOF
 	case lexer.STRING:
+		if r.maxString > 0 && len(t.Lit) > r.maxString {
+			return nil, &LimitError{"string size", r.maxString}
+		}
 		return StringWithValue(t.Lit), nil
 	case lexer.TRUE:
 		return T, nil
@@ -109,22 +134,42 @@ func read(l Tokenizer) (Data, error) {
 		return _dot, nil
 	case lexer.ILLEGAL:
 		return nil, errors.New(t.Lit)
-	case lexer.COMMENT:
-		return read(l)
+	case lexer.COMMENT, lexer.DIRECTIVE:
+		return r.read()
+	case lexer.DATUM_LABEL:
+		return r.readLabel(t)
+	case lexer.DATUM_REF:
+		d, ok := r.labels[t.Lit]
+		if !ok {
+			return nil, fmt.Errorf("%v: undefined datum label #%v#", t.Pos, t.Lit)
+		}
+		if ph, ok := d.(*placeholder); ok {
+			ph.used = true
+		}
+		return d, nil
+	case lexer.DATUM_COMMENT:
+		skipped, err := r.read()
+		if err != nil {
+			return nil, err
+		}
+		if skipped == nil || skipped == _dot {
+			return nil, errors.New("#; must be followed by a datum")
+		}
+		return r.read()
 	}
 	return nil, errors.New("Malformed input")
 }
 
-func readQuote(lex Tokenizer) (Data, error) {
-	c, err := read(lex)
+func (r *reader) readQuote(pos lexer.Pos) (Data, error) {
+	c, err := r.read()
 	if err != nil {
 		return nil, fmt.Errorf("Failed to complete list: %v\n", err)
 	}
-	return cons(internSymbol("quote"), cons(c, Empty)), nil
+	return &Pair{car: r.syms.quote, cdr: cons(c, Empty), pos: &pos}, nil
 }
 
-func readList2(lex Tokenizer) (Data, error) {
-	c, err := read(lex)
+func (r *reader) readList2() (Data, error) {
+	c, err := r.read()
 	if err != nil {
 		return nil, fmt.Errorf("Failed to complete list: %v\n", err)
 	}
@@ -134,292 +179,204 @@ func readList2(lex Tokenizer) (Data, error) {
 
 	// handle (a b . c) but (a b . c d) is an error.
 	if c == _dot {
-		last, err := read(lex)
+		last, err := r.read()
 		if err != nil {
 			return nil, err
 		}
-		end, _ := read(lex)
+		end, _ := r.read()
 		if end != nil {
 			return nil, fmt.Errorf("More than one object follows .")
 		}
 
-		log.Printf("last %v", last)
+		r.log.Printf("last %v", last)
 		return last, nil
 	}
 
-	rest, err := readList2(lex)
+	rest, err := r.readList2()
 	if err != nil {
 		return nil, err
 	}
 	return cons(c, rest), nil
 }
 
-func readList(lex Tokenizer) (Data, error) {
-	li, err := readList2(lex)
+func (r *reader) readList(pos lexer.Pos) (Data, error) {
+	li, err := r.readList2()
 	if err != nil {
 		return nil, err
 	}
-	return li, nil
-}
-
-var (
-	_quote  = internSymbol("quote")
-	_define = internSymbol("define")
-	_dot    = internSymbol("::dot::")
-	_set    = internSymbol("set!")
-	_if     = internSymbol("if")
-	_begin  = internSymbol("begin")
-	_quit   = internSymbol("quit")
-	_lambda = internSymbol("lambda")
-	_let    = internSymbol("let")
-	_vars   = internSymbol(":vars")
-	_ok     = internSymbol("ok")
-)
-
-func eval(expr Data, env *Env) (Data, error) {
-	log.Printf("eval: %T: %v\n", expr, expr)
-	switch e := expr.(type) {
-	case Boolean:
-		return e, nil
-	case Symbol:
-		return env.FindVar(e)
-	case Number:
-		return e, nil
-	case String:
-		return e, nil
-	case Null:
-		return e, nil
-	case *Pair:
-		c, _ := getSymbol(car(e))
-		/* non-Symbols fall through to default */
-		switch c {
-		case _quote:
-			return cadr(e), nil
-		case _define:
-			return evalDefine(e, env)
-		case _set:
-			return evalSet(e, env)
-		case _if:
-			return evalIf(e, env)
-		case _let:
-			return evalLet(e, env)
-		case _begin:
-			return evalSequential(cdr(e), env)
-		case _quit:
-			os.Exit(0)
-		case _lambda:
-			params, err := getList(cadr(e))
-			if err != nil {
-				return nil, fmt.Errorf("bad params: %v", err)
-			}
-			body, err := getList(cddr(e))
-			if err != nil {
-				return nil, fmt.Errorf("bad body: %v", err)
-			}
-			return evalLambda(params, body, env)
-		case _vars:
-			for k, v := range env.vars {
-				log.Printf("%v: %v\n", k, v)
-			}
-			return nil, nil
-		default:
-			log.Printf("procedure call %v", e)
-			proc, err := eval(car(e), env)
-			if err != nil {
-				return nil, err
-			}
-			args, err := evalArgs(cdr(e), env)
-			if err != nil {
-				return nil, err
-			}
-			switch f := proc.(type) {
-			case InternalFunc:
-				return f(args)
-			case *Lambda:
-				var err error
-				env, err = ExtendEnv(f.params, args, f.envt)
-				if err != nil {
-					return nil, err
-				}
-				return evalSequential(f.body, env)
-			default:
-				return nil, fmt.Errorf("apply to a non function: %#v %v", proc, args)
-			}
-
-		}
-	case nil:
-		log.Fatal("parsed a nil?")
-		return nil, nil
+	if p, ok := li.(*Pair); ok {
+		p.pos = &pos
 	}
-	return nil, fmt.Errorf("Unparsable expression: %v", expr)
+	return li, nil
 }
 
-func evalDefine(e *Pair, env *Env) (Data, error) {
-	expr, err := getPair(cdr(e))
+// readVector reads the elements of a #( ... ) vector literal.
+func (r *reader) readVector() (Data, error) {
+	li, err := r.readList2()
 	if err != nil {
 		return nil, err
 	}
-	err = definition(expr, env)
+	items, err := listSlice(li)
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("bad vector literal: %v", err)
 	}
-	// Return value of define is undefined
-	return _ok, nil
+	return NewVector(items...), nil
 }
 
-func evalSet(e *Pair, env *Env) (Data, error) {
-	d, err := getSymbol(cadr(e))
-	if err != nil {
-		return nil, err
+// placeholder stands for a labelled datum in references to it that are
+// read before the datum is complete.
+type placeholder struct {
+	used bool
+}
+
+// readLabel reads the datum labelled by #n=, replacing references to it
+// from within itself once it is read.
+func (r *reader) readLabel(t *lexer.TokenItem) (Data, error) {
+	if r.labels == nil {
+		r.labels = make(map[string]Data)
 	}
-	val, err := eval(caddr(e), env)
+	ph := &placeholder{}
+	r.labels[t.Lit] = ph
+	d, err := r.read()
 	if err != nil {
 		return nil, err
 	}
-	env.Find(d).Bind(d, val)
-	return _ok, nil
-}
-
-func evalIf(e *Pair, env *Env) (Data, error) {
-	test, _ := eval(cadr(e), env)
-	if isTrue(test) {
-		return eval(caddr(e), env)
-	} else if listLen(e) > 3 {
-		return eval(cadddr(e), env)
+	switch d {
+	case nil, _dot:
+		return nil, fmt.Errorf("%v: #%v= must be followed by a datum", t.Pos, t.Lit)
+	case ph:
+		return nil, fmt.Errorf("%v: #%v= cannot label only itself", t.Pos, t.Lit)
 	}
-	return Empty, nil
+	r.labels[t.Lit] = d
+	if ph.used {
+		replacePlaceholder(d, ph)
+	}
+	return d, nil
 }
 
-func evalLet(e *Pair, env *Env) (Data, error) {
-	letExpr, err := let(cdr(e), env)
-	if err != nil {
-		return nil, err
+// replacePlaceholder replaces ph by d in the pairs and vectors of d. It
+// keeps a stack of its own, as lists can be long.
+func replacePlaceholder(d Data, ph *placeholder) {
+	seen := make(map[Data]bool)
+	stack := []Data{d}
+	for len(stack) > 0 {
+		x := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if seen[x] {
+			continue
+		}
+		seen[x] = true
+		switch x := x.(type) {
+		case *Pair:
+			if x.car == ph {
+				x.car = d
+			}
+			if x.cdr == ph {
+				x.cdr = d
+			}
+			stack = append(stack, x.car, x.cdr)
+		case *Vector:
+			for i, item := range x.items {
+				if item == ph {
+					x.items[i] = d
+				}
+				stack = append(stack, item)
+			}
+		}
 	}
-	return letExpr, nil
 }
 
-func evalSequential(e Data, env *Env) (Data, error) {
-	var v Data
-	e, err := getPair(e)
-	if err != nil {
+// _dot marks the dot of a dotted pair while a list is being read.
+var _dot = Symbol("::dot::")
+
+// apply calls proc with the list of evaluated args.
+func (in *Interpreter) apply(proc Data, args Data) (Data, error) {
+	if err := in.enter(); err != nil {
 		return nil, err
 	}
-	for !nullp(e) {
-		log.Printf("begin: %v", e)
-		v, err = eval(car(e), env)
-		if err != nil {
+	defer func() { in.depth-- }()
+	switch f := proc.(type) {
+	case InternalFunc:
+		return f(args)
+	case *Builtin:
+		if err := f.checkArgs(args); err != nil {
 			return nil, err
 		}
-		if nullp(cdr(e)) {
-			break
+		if f.traced {
+			in.traceEnter(f.name, args)
 		}
-		e, err = listNext(e)
-		if err != nil {
-			return nil, err
-		}
-
-	}
-	return v, nil
-
-}
-func definition(defn *Pair, env *Env) error {
-	var value Data
-	var name Symbol
-	var err error
-	switch e := car(defn).(type) {
-	// (define var value)
-	case Symbol:
-		name = e
-		value, err = eval(cadr(defn), env)
-		if err != nil {
-			return err
+		v, err := f.fn(args)
+		if f.traced {
+			in.traceExit(f.name, v, err)
 		}
-	// (define (proc a b) (body))
-	case *Pair:
-		name, err = getSymbol(car(e))
-		if err != nil {
-			return err
+		return v, err
+	case *Lambda:
+		if f.traced {
+			in.traceEnter(f.name, args)
 		}
-		params := cdr(e)
-
-		body, err := getPair(cdr(defn))
+		items, err := listSlice(args)
 		if err != nil {
-			return err
+			return nil, err
 		}
-		value, err = evalLambda(params, body, env)
-		if err != nil {
-			return err
+		v, err := in.call(f, items)
+		if f.traced {
+			in.traceExit(f.name, v, err)
 		}
+		return v, err
 	}
-
-	env.Bind(name, value)
-	return nil
+	return nil, fmt.Errorf("apply to a non function: %#v %v", proc, args)
 }
 
 type Lambda struct {
 	index  int
+	name   Symbol
 	params []Symbol
-	body   Data
+	frame  []Symbol  // the slots of the frame of a call
+	body   execution // run by the analyzer
+	code   *code     // run by the VM
 	envt   *Env
+	traced bool
 }
 
-var lambdaCounter int = 0
-
-func NewLambda() *Lambda {
-	l := &Lambda{
-		index: lambdaCounter,
+// bind returns the frame for a call of l with args.
+func (l *Lambda) bind(args []Data) (*Env, error) {
+	if len(args) != len(l.params) {
+		return nil, fmt.Errorf("%v: parameter mismatch %v != %v", l, l.params, List(args...))
 	}
-	lambdaCounter++
-	return l
-}
-func (l *Lambda) String() string {
-	return fmt.Sprintf("#<compound-procedure: #%d>", l.index)
+	slots := make([]Data, len(l.frame))
+	copy(slots, args)
+	return &Env{names: l.frame, slots: slots, outer: l.envt, interp: l.envt.interp}, nil
 }
 
-func evalLambda(params Data, body Data, env *Env) (Data, error) {
-	l := NewLambda()
-	for params != Empty {
-		var err error
-		p, err := getPair(params)
-		if err != nil {
-			return nil, fmt.Errorf("not a pair here %v", params)
-		}
-		switch arg := car(p).(type) {
-		case Symbol:
-			l.params = append(l.params, arg)
-		case *Pair:
-			return nil, fmt.Errorf("combo param not supported: %v", arg)
-		}
-		params = cdr(p)
-	}
-	l.body = body
-	l.envt = NewEnv(env)
-	return l, nil
-}
-
-func evalArgs(next Data, env *Env) (Data, error) {
-	if nullp(next) {
-		return Empty, nil
-	}
-	e, err := getPair(next)
+// call runs the body of l with its parameters bound to args.
+func (in *Interpreter) call(l *Lambda, args []Data) (Data, error) {
+	env, err := l.bind(args)
 	if err != nil {
 		return nil, err
 	}
-
-	val, err := eval(car(e), env)
-	if err != nil {
-		return nil, err
+	if l.code != nil {
+		return in.run(l.code, env)
 	}
+	return l.body(env)
+}
 
-	if nullp(cdr(e)) {
-		return cons(val, Empty), nil
-	} else {
-		rest, err := evalArgs(cdr(e), env)
-		if err != nil {
-			return nil, err
-		}
-		return cons(val, rest), nil
+func (in *Interpreter) newLambda() *Lambda {
+	l := &Lambda{
+		index: in.lambdaCounter,
 	}
+	in.lambdaCounter++
+	return l
+}
+func (l *Lambda) String() string {
+	name := fmt.Sprintf("#%d", l.index)
+	if l.name != "" {
+		name = string(l.name)
+	}
+	params := make([]string, len(l.params))
+	for i, p := range l.params {
+		params[i] = string(p)
+	}
+	return fmt.Sprintf("#<procedure %s (%s)>", name, strings.Join(params, " "))
 }
 
 func getError(d Data) error {
@@ -449,7 +406,9 @@ func internalMap(f func(Data) Data, d Data) Data {
 	return cons(e, internalMap(f, rest))
 }
 
-func let(expr Data, env *Env) (Data, error) {
+// expandLet rewrites (let ((name value) ...) body...) as the
+// application ((lambda (name ...) body...) value ...).
+func expandLet(expr Data, syms *SymbolTable) (Data, error) {
 	arguments := internalMap(car, car(expr))
 	if err := getError(arguments); err != nil {
 		return nil, err
@@ -465,21 +424,133 @@ func let(expr Data, env *Env) (Data, error) {
 		return nil, err
 	}
 
-	result := cons(cons(_lambda, cons(arguments, body)), values)
-	log.Printf("lambda %v", result)
+	return cons(cons(syms.lambda, cons(arguments, body)), values), nil
+}
 
-	return eval(result, env)
+// expandLetrec rewrites (letrec* ((name value) ...) body...) as
+// ((lambda () (define name value) ... body...)), as the internal
+// definitions of a body are evaluated in turn like letrec*. letrec is
+// expanded the same way.
+func expandLetrec(expr Data, syms *SymbolTable) (Data, error) {
+	bindings, err := listSlice(car(expr))
+	if err != nil {
+		return nil, err
+	}
+	body := cdr(expr)
+	if _, err := getPair(body); err != nil {
+		return nil, err
+	}
+	for i := len(bindings) - 1; i >= 0; i-- {
+		b, err := getPair(bindings[i])
+		if err != nil {
+			return nil, err
+		}
+		body = cons(cons(syms.define, b), body)
+	}
+	return List(cons(syms.lambda, cons(Empty, body))), nil
+}
+
+// expand rewrites the derived expressions within form into the core
+// special forms they are evaluated as.
+func expand(form Data, syms *SymbolTable) (Data, error) {
+	p, ok := form.(*Pair)
+	if !ok {
+		return form, nil
+	}
+	c, _ := getSymbol(car(p))
+	switch syms.forms[c] {
+	case formQuote:
+		return form, nil
+	case formLet:
+		e, err := expandLet(cdr(p), syms)
+		if err != nil {
+			return nil, err
+		}
+		return expand(e, syms)
+	case formLetrec:
+		e, err := expandLetrec(cdr(p), syms)
+		if err != nil {
+			return nil, err
+		}
+		return expand(e, syms)
+	}
+
+	var items []Data
+	var d Data = p
+	for pairp(d) {
+		e, err := expand(car(d), syms)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, e)
+		d = cdr(d)
+	}
+	for i := len(items) - 1; i >= 0; i-- {
+		d = cons(items[i], d)
+	}
+	return d, nil
+}
+
+// checkCycles returns an error if the code in form contains itself,
+// which datum labels let the reader make. Such code would send the
+// analyzer, compiler or expander round the cycle for ever. Quoted data
+// and vectors are not evaluated, so they may be circular.
+func checkCycles(form Data, syms *SymbolTable) error {
+	active := make(map[*Pair]bool) // true while on the stack
+	type entry struct {
+		p    *Pair
+		next int
+	}
+	var stack []entry
+	visit := func(d Data) bool {
+		p, ok := d.(*Pair)
+		if !ok {
+			return true
+		}
+		if on, seen := active[p]; seen {
+			return !on
+		}
+		if s, ok := p.car.(Symbol); ok && syms.forms[s] == formQuote {
+			active[p] = false
+			return true
+		}
+		active[p] = true
+		stack = append(stack, entry{p: p})
+		return true
+	}
+	visit(form)
+	for len(stack) > 0 {
+		top := &stack[len(stack)-1]
+		child, ok := nthChild(top.p, top.next)
+		if !ok {
+			active[top.p] = false
+			stack = stack[:len(stack)-1]
+			continue
+		}
+		top.next++
+		if !visit(child) {
+			return fmt.Errorf("circular code: %v", form)
+		}
+	}
+	return nil
 }
 
 func replReader(in io.Reader, env *Env) (Data, error) {
-	//	l := NewScanner(in)
-	buf := make([]byte, 1024)
-	n, _ := in.Read(buf)
-	l := lexer.New("lispy", string(buf[:n]))
+	return replNamed("lispy", in, env)
+}
+
+// replNamed evaluates every expression read from in, reporting source
+// locations relative to name.
+func replNamed(name string, in io.Reader, env *Env) (Data, error) {
+	buf, err := ioutil.ReadAll(in)
+	if err != nil {
+		return nil, err
+	}
+	r := newReader(lexer.New(name, string(buf)), env.interp)
 	var result Data
 	for {
 		var err error
-		expr, err := read(l)
+		expr, err := r.readDatum()
 		//log.Println(expr, err)
 		if err != nil {
 			if err == ErrorEOF {
@@ -487,6 +558,9 @@ func replReader(in io.Reader, env *Env) (Data, error) {
 			}
 			return result, err
 		}
+		if err := checkCycles(expr, env.interp.syms); err != nil {
+			return result, err
+		}
 
 		result, err = eval(expr, env)
 		if err != nil {
@@ -499,8 +573,18 @@ func repl(in string, env *Env) (Data, error) {
 	return replReader(strings.NewReader(in), env)
 }
 
-func ApplyNumeric(f func(Number, Number) Number) InternalFunc {
-	return func(d Data) (Data, error) {
+// loadFile evaluates the contents of the named file in env.
+func loadFile(name string, env *Env) (Data, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return replNamed(name, f, env)
+}
+
+func ApplyNumeric(f func(Number, Number) Number) *Builtin {
+	return NewBuiltin(0, -1, func(d Data) (Data, error) {
 		if nullp(d) {
 			return 0, nil
 		}
@@ -531,11 +615,11 @@ func ApplyNumeric(f func(Number, Number) Number) InternalFunc {
 			v = f(v, i)
 		}
 		return v, nil
-	}
+	})
 }
 
-func ApplyNumericBool(f func(Number, Number) Boolean) InternalFunc {
-	return func(d Data) (Data, error) {
+func ApplyNumericBool(f func(Number, Number) Boolean) *Builtin {
+	return NewBuiltin(0, -1, func(d Data) (Data, error) {
 		var ret Boolean
 		if nullp(d) {
 			return 0, nil
@@ -571,20 +655,20 @@ func ApplyNumericBool(f func(Number, Number) Boolean) InternalFunc {
 			v = i
 		}
 		return Boolean(ret), nil
-	}
+	})
 }
 
-func Apply1(f func(Data) (Data, error)) InternalFunc {
-	return func(args Data) (Data, error) {
+func Apply1(f func(Data) (Data, error)) *Builtin {
+	return NewBuiltin(1, 1, func(args Data) (Data, error) {
 		if listLen(args) != 1 {
 			return nil, fmt.Errorf("Expected 1 arguments, received %d", listLen(args))
 		}
 		return f(car(args))
-	}
+	})
 }
 
-func Apply2(f func(Data, Data) (Data, error)) InternalFunc {
-	return func(args Data) (Data, error) {
+func Apply2(f func(Data, Data) (Data, error)) *Builtin {
+	return NewBuiltin(2, 2, func(args Data) (Data, error) {
 		if listLen(args) != 2 {
 			return nil, fmt.Errorf("Expected 2 arguments, received %d", listLen(args))
 		}
@@ -594,11 +678,10 @@ func Apply2(f func(Data, Data) (Data, error)) InternalFunc {
 			return nil, err
 		}
 		return f(car(a), cadr(a))
-	}
+	})
 }
 
 func isTrue(i Data) Boolean {
-	log.Printf("isTrue %T %v", i, i)
 	if b, ok := i.(Boolean); ok {
 		return b
 	}
@@ -624,8 +707,29 @@ func EmptyEnv() *Env {
 	return NewEnv(nil)
 }
 
+// NewTopEnv returns the empty top level environment of a new interpreter
+// for dialect d.
+func NewTopEnv(d Dialect) *Env {
+	in := &Interpreter{
+		syms:        NewSymbolTable(d),
+		Stdout:      os.Stdout,
+		TraceOutput: os.Stdout,
+		Warnings:    os.Stderr,
+		log:         log.New(os.Stderr, log.Info),
+		engine:      defaultEngine,
+	}
+	in.env = &Env{vars: make(map[Symbol]*cell), interp: in}
+	return in.env
+}
+
 func DefaultEnv() *Env {
-	env := EmptyEnv()
+	return NewDefaultEnv(Legacy)
+}
+
+// NewDefaultEnv returns a top level environment for dialect d with the
+// standard procedures bound.
+func NewDefaultEnv(d Dialect) *Env {
+	env := NewTopEnv(d)
 	env.BindName("*", ApplyNumeric(func(x, y Number) Number {
 		return x * y
 	}))
@@ -666,25 +770,100 @@ func DefaultEnv() *Env {
 		}
 		return False, nil
 	}))
-	env.BindName("equal?", InternalFunc(func(args Data) (Data, error) {
-		if listLen(args) != 2 {
-			return nil, fmt.Errorf("wrong number of arguments given to equal?")
-		}
-		a, err := getPair(args)
-		if err != nil {
+	env.BindName("pi", Number(math.Pi))
+	env.BindName("cons", Apply2(func(a, b Data) (Data, error) {
+		if err := env.interp.alloc(1); err != nil {
 			return nil, err
 		}
-		return Boolean(reflect.DeepEqual(car(a), cadr(a))), nil
+		return _cons(a, b)
 	}))
-	env.BindName("pi", Number(math.Pi))
-	env.BindName("cons", Apply2(_cons))
 	env.BindName("car", Apply1(_car))
 	env.BindName("cdr", Apply1(_cdr))
+	env.BindName("set-car!", Apply2(func(p, v Data) (Data, error) {
+		if err := _setCar(p, v); err != nil {
+			return nil, err
+		}
+		return env.interp.syms.ok, nil
+	}))
+	env.BindName("set-cdr!", Apply2(func(p, v Data) (Data, error) {
+		if err := _setCdr(p, v); err != nil {
+			return nil, err
+		}
+		return env.interp.syms.ok, nil
+	}))
 	env.BindName("null?", Apply1(_nullp))
 	env.BindName("pair?", Apply1(_pairp))
+	env.BindName("procedure?", Apply1(_procedurep))
+	env.BindName("procedure-name", Apply1(_procedureName))
+	env.BindName("procedure-arity", Apply1(_procedureArity))
+	env.BindName("load", Apply1(func(a Data) (Data, error) {
+		name, ok := a.(String)
+		if !ok {
+			return nil, fmt.Errorf("load: file name must be a string: %v", a)
+		}
+		return loadFile(string(name), env)
+	}))
+	env.BindName("exit", NewBuiltin(0, 1, _exit))
+	bindEquivalence(env)
+	bindLists(env)
+	bindVectors(env)
+	bindHashTables(env)
+	bindForeign(env)
+	bindDisassemble(env)
+	env.BindName("display", Apply1(func(a Data) (Data, error) {
+		if s, ok := a.(String); ok {
+			fmt.Fprint(env.interp.Stdout, string(s))
+		} else {
+			fmt.Fprint(env.interp.Stdout, a)
+		}
+		return env.interp.syms.ok, nil
+	}))
+	env.BindName("write", Apply1(func(a Data) (Data, error) {
+		fmt.Fprint(env.interp.Stdout, writeDatum(a))
+		return env.interp.syms.ok, nil
+	}))
+	env.BindName("write-shared", Apply1(func(a Data) (Data, error) {
+		fmt.Fprint(env.interp.Stdout, writeShared(a))
+		return env.interp.syms.ok, nil
+	}))
+	env.BindName("newline", NewBuiltin(0, 0, func(Data) (Data, error) {
+		fmt.Fprintln(env.interp.Stdout)
+		return env.interp.syms.ok, nil
+	}))
+	for _, c := range env.vars {
+		c.builtin = true
+	}
 	return env
 }
 
+// ExitError is returned when a program calls exit, Code is the
+// requested exit status.
+type ExitError struct {
+	Code int
+}
+
+func (e *ExitError) Error() string {
+	return fmt.Sprintf("exit status %d", e.Code)
+}
+
+// _exit implements (exit [status]), #t and no status mean success and
+// #f failure.
+func _exit(args Data) (Data, error) {
+	if nullp(args) {
+		return nil, &ExitError{0}
+	}
+	switch v := car(args).(type) {
+	case Number:
+		return nil, &ExitError{int(v)}
+	case Boolean:
+		if v {
+			return nil, &ExitError{0}
+		}
+		return nil, &ExitError{1}
+	}
+	return nil, fmt.Errorf("exit: bad status %v", car(args))
+}
+
 func _cons(a Data, b Data) (Data, error) {
 	return cons(a, b), nil
 }
@@ -705,6 +884,24 @@ func _cdr(a Data) (Data, error) {
 	return cdr(p), nil
 }
 
+func _setCar(p, v Data) error {
+	pair, err := getPair(p)
+	if err != nil {
+		return fmt.Errorf("set-car!: %v", err)
+	}
+	pair.car = v
+	return nil
+}
+
+func _setCdr(p, v Data) error {
+	pair, err := getPair(p)
+	if err != nil {
+		return fmt.Errorf("set-cdr!: %v", err)
+	}
+	pair.cdr = v
+	return nil
+}
+
 func _nullp(a Data) (Data, error) {
 	return nullp(a), nil
 }
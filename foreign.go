@@ -0,0 +1,553 @@
+package rsi
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"strings"
+)
+
+var dataType = reflect.TypeOf((*Data)(nil)).Elem()
+
+// Foreign holds a Go value that has no Scheme equivalent, such as a
+// struct or a pointer. Programs can pass it around and look inside it
+// with go-field, go-index and go-call, and change the slice or map it
+// holds with go-index-set!.
+type Foreign struct {
+	v interface{}
+}
+
+// NewForeign wraps v without converting it.
+func NewForeign(v interface{}) *Foreign {
+	return &Foreign{v: v}
+}
+
+// Value returns the wrapped Go value.
+func (f *Foreign) Value() interface{} {
+	return f.v
+}
+
+func (f *Foreign) String() string {
+	return fmt.Sprintf("#<go %T>", f.v)
+}
+
+// FromGo converts a Go value to Scheme. Scheme values are returned
+// unchanged. Strings, booleans and numbers become their Scheme types,
+// slices and arrays become vectors and maps hash tables, with their
+// elements converted in turn. nil is the empty list, and anything else
+// is wrapped as a *Foreign. A slice or map that contains itself cannot
+// be converted.
+func FromGo(v interface{}) (Data, error) {
+	return new(converter).fromGo(reflect.ValueOf(v))
+}
+
+// ToGo converts a Scheme value to its natural Go form: numbers become
+// float64, strings string, booleans bool, and lists and vectors
+// []interface{}. A hash table becomes a map[string]interface{} if all of
+// its keys are strings, otherwise a map[interface{}]interface{}. Foreign
+// objects give the value they hold, and other values, including
+// improper lists, are returned unchanged. Circular structure cannot be
+// converted.
+func ToGo(d Data) (interface{}, error) {
+	return new(converter).native(d)
+}
+
+// converter converts values between Scheme and Go, keeping track of the
+// containers it is inside so that a cycle is an error rather than an
+// endless recursion.
+type converter struct {
+	active map[interface{}]bool
+}
+
+// goRef identifies the contents of a Go slice or map.
+type goRef struct {
+	t   reflect.Type
+	ptr uintptr
+	len int
+}
+
+// enter records that the container k is being converted, failing if it
+// already is.
+func (c *converter) enter(k interface{}, what interface{}) error {
+	if c.active == nil {
+		c.active = make(map[interface{}]bool)
+	}
+	if c.active[k] {
+		return fmt.Errorf("cannot convert a %s that contains itself", what)
+	}
+	c.active[k] = true
+	return nil
+}
+
+func (c *converter) leave(k interface{}) {
+	delete(c.active, k)
+}
+
+func (c *converter) native(d Data) (interface{}, error) {
+	switch v := d.(type) {
+	case Number:
+		return float64(v), nil
+	case String:
+		return string(v), nil
+	case Boolean:
+		return bool(v), nil
+	case Null:
+		return []interface{}{}, nil
+	case *Pair:
+		pairs, tail, err := pairsOf(v)
+		if err != nil {
+			return nil, fmt.Errorf("cannot convert a circular list")
+		}
+		if !nullp(tail) {
+			return v, nil
+		}
+		items := make([]Data, len(pairs))
+		for i, p := range pairs {
+			items[i] = p.car
+		}
+		if err := c.enter(v, "list"); err != nil {
+			return nil, err
+		}
+		defer c.leave(v)
+		return c.nativeSlice(items)
+	case *Vector:
+		if err := c.enter(v, "vector"); err != nil {
+			return nil, err
+		}
+		defer c.leave(v)
+		return c.nativeSlice(v.items)
+	case *HashTable:
+		if err := c.enter(v, "hash table"); err != nil {
+			return nil, err
+		}
+		defer c.leave(v)
+		strs := make(map[string]interface{}, len(v.m))
+		for _, e := range v.m {
+			s, ok := e.key.(String)
+			if !ok {
+				strs = nil
+				break
+			}
+			x, err := c.native(e.value)
+			if err != nil {
+				return nil, err
+			}
+			strs[string(s)] = x
+		}
+		if strs != nil {
+			return strs, nil
+		}
+		m := make(map[interface{}]interface{}, len(v.m))
+		for _, e := range v.m {
+			key, err := c.native(e.key)
+			if err != nil {
+				return nil, err
+			}
+			x, err := c.native(e.value)
+			if err != nil {
+				return nil, err
+			}
+			m[key] = x
+		}
+		return m, nil
+	case *Foreign:
+		return v.v, nil
+	}
+	return d, nil
+}
+
+func (c *converter) nativeSlice(items []Data) ([]interface{}, error) {
+	s := make([]interface{}, len(items))
+	for i, d := range items {
+		var err error
+		if s[i], err = c.native(d); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// anyType reports whether t is an empty interface other than Data.
+func anyType(t reflect.Type) bool {
+	return t != dataType && t.Kind() == reflect.Interface && t.NumMethod() == 0
+}
+
+// isDataType reports whether t is one of the concrete types of Scheme
+// values.
+func isDataType(t reflect.Type) bool {
+	switch reflect.Zero(t).Interface().(type) {
+	case Number, String, Symbol, Boolean, Null, *Pair, InternalFunc, *Builtin, *Lambda,
+		*Vector, *HashTable, *Foreign:
+		return true
+	}
+	return false
+}
+
+// toGo converts d to a value of type t. Data receives d unchanged and
+// other empty interfaces the result of ToGo.
+func (c *converter) toGo(d Data, t reflect.Type) (reflect.Value, error) {
+	if t == dataType {
+		if d == nil {
+			return reflect.Zero(t), nil
+		}
+		return reflect.ValueOf(d), nil
+	}
+	if anyType(t) {
+		var err error
+		if d, err = c.native(d); err != nil {
+			return reflect.Value{}, err
+		}
+	} else if f, ok := d.(*Foreign); ok && f.v != nil && reflect.TypeOf(f.v).AssignableTo(t) {
+		return reflect.ValueOf(f.v), nil
+	}
+	if d == nil {
+		return reflect.Zero(t), nil
+	}
+	if reflect.TypeOf(d).AssignableTo(t) {
+		return reflect.ValueOf(d), nil
+	}
+	v := reflect.New(t).Elem()
+	switch t.Kind() {
+	case reflect.String:
+		if s, ok := d.(String); ok {
+			v.SetString(string(s))
+			return v, nil
+		}
+		return v, fmt.Errorf("expected a string, got %v", d)
+	case reflect.Bool:
+		if b, ok := d.(Boolean); ok {
+			v.SetBool(bool(b))
+			return v, nil
+		}
+		return v, fmt.Errorf("expected a boolean, got %v", d)
+	case reflect.Float32, reflect.Float64:
+		if n, ok := d.(Number); ok {
+			v.SetFloat(float64(n))
+			return v, nil
+		}
+		return v, fmt.Errorf("expected a number, got %v", d)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, ok := d.(Number)
+		if !ok || n != Number(math.Trunc(float64(n))) {
+			return v, fmt.Errorf("expected an integer, got %v", d)
+		}
+		// compare as a float, as converting first would wrap around
+		limit := Number(math.Ldexp(1, t.Bits()-1))
+		if n < -limit || n >= limit {
+			return v, fmt.Errorf("%v is out of range for %v", d, t)
+		}
+		v.SetInt(int64(n))
+		return v, nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, ok := d.(Number)
+		if !ok || n != Number(math.Trunc(float64(n))) || n < 0 {
+			return v, fmt.Errorf("expected a non-negative integer, got %v", d)
+		}
+		if n >= Number(math.Ldexp(1, t.Bits())) {
+			return v, fmt.Errorf("%v is out of range for %v", d, t)
+		}
+		v.SetUint(uint64(n))
+		return v, nil
+	case reflect.Slice, reflect.Array:
+		var items []Data
+		switch s := d.(type) {
+		case *Vector:
+			items = s.items
+		case *Pair, Null:
+			var err error
+			if items, err = listSlice(s); err != nil {
+				return v, err
+			}
+		default:
+			return v, fmt.Errorf("expected a list or vector, got %v", d)
+		}
+		if d != Empty {
+			if err := c.enter(d, "list or vector"); err != nil {
+				return v, err
+			}
+			defer c.leave(d)
+		}
+		if t.Kind() == reflect.Array {
+			if len(items) != t.Len() {
+				return v, fmt.Errorf("expected %d elements, got %d", t.Len(), len(items))
+			}
+		} else {
+			v = reflect.MakeSlice(t, len(items), len(items))
+		}
+		for i, item := range items {
+			e, err := c.toGo(item, t.Elem())
+			if err != nil {
+				return v, fmt.Errorf("element %d: %v", i, err)
+			}
+			v.Index(i).Set(e)
+		}
+		return v, nil
+	case reflect.Map:
+		h, ok := d.(*HashTable)
+		if !ok {
+			return v, fmt.Errorf("expected a hash table, got %v", d)
+		}
+		if err := c.enter(h, "hash table"); err != nil {
+			return v, err
+		}
+		defer c.leave(h)
+		v = reflect.MakeMapWithSize(t, len(h.m))
+		for _, kv := range h.m {
+			k, err := c.toGo(kv.key, t.Key())
+			if err != nil {
+				return v, fmt.Errorf("key %v: %v", kv.key, err)
+			}
+			e, err := c.toGo(kv.value, t.Elem())
+			if err != nil {
+				return v, fmt.Errorf("value of %v: %v", kv.key, err)
+			}
+			v.SetMapIndex(k, e)
+		}
+		return v, nil
+	}
+	return v, fmt.Errorf("cannot convert %v to %v", d, t)
+}
+
+func (c *converter) fromGo(v reflect.Value) (Data, error) {
+	if !v.IsValid() {
+		return Empty, nil
+	}
+	if v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return Empty, nil
+		}
+		v = v.Elem()
+	}
+	if isDataType(v.Type()) {
+		return v.Interface(), nil
+	}
+	switch v.Kind() {
+	case reflect.String:
+		return String(v.String()), nil
+	case reflect.Bool:
+		return Boolean(v.Bool()), nil
+	case reflect.Float32, reflect.Float64:
+		return Number(v.Float()), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return Number(v.Int()), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return Number(v.Uint()), nil
+	case reflect.Slice, reflect.Array:
+		if v.Kind() == reflect.Slice && v.Len() > 0 {
+			ref := goRef{v.Type(), v.Pointer(), v.Len()}
+			if err := c.enter(ref, v.Type()); err != nil {
+				return nil, err
+			}
+			defer c.leave(ref)
+		}
+		items := make([]Data, v.Len())
+		for i := range items {
+			var err error
+			if items[i], err = c.fromGo(v.Index(i)); err != nil {
+				return nil, err
+			}
+		}
+		return NewVector(items...), nil
+	case reflect.Map:
+		if !v.IsNil() {
+			ref := goRef{v.Type(), v.Pointer(), 0}
+			if err := c.enter(ref, v.Type()); err != nil {
+				return nil, err
+			}
+			defer c.leave(ref)
+		}
+		h := NewHashTable()
+		iter := v.MapRange()
+		for iter.Next() {
+			k, err := c.fromGo(iter.Key())
+			if err != nil {
+				return nil, err
+			}
+			e, err := c.fromGo(iter.Value())
+			if err != nil {
+				return nil, err
+			}
+			h.Set(k, e)
+		}
+		return h, nil
+	}
+	return &Foreign{v: v.Interface()}, nil
+}
+
+func bindForeign(env *Env) {
+	env.BindName("go-object?", Apply1(func(a Data) (Data, error) {
+		_, ok := a.(*Foreign)
+		return Boolean(ok), nil
+	}))
+	env.BindName("go-field", Apply2(_goField))
+	env.BindName("go-index", Apply2(_goIndex))
+	env.BindName("go-index-set!", NewBuiltin(3, 3, func(args Data) (Data, error) {
+		if err := goIndexSet(car(args), cadr(args), caddr(args)); err != nil {
+			return nil, err
+		}
+		return env.interp.syms.ok, nil
+	}))
+	env.BindName("go-call", NewBuiltin(2, -1, func(args Data) (Data, error) {
+		f, err := getForeign("go-call", car(args))
+		if err != nil {
+			return nil, err
+		}
+		rv := reflect.ValueOf(f.v)
+		match, err := matchName("go-call", cadr(args))
+		if err != nil {
+			return nil, err
+		}
+		for i := 0; rv.IsValid() && i < rv.NumMethod(); i++ {
+			name := rv.Type().Method(i).Name
+			if !match(name) {
+				continue
+			}
+			b, err := env.interp.Func(rv.Method(i).Interface())
+			if err != nil {
+				return nil, fmt.Errorf("go-call: %s: %v", name, err)
+			}
+			b.name = Symbol(name)
+			return b.fn(cddr(args))
+		}
+		return nil, fmt.Errorf("go-call: %v has no method %v", f, cadr(args))
+	}))
+}
+
+func getForeign(name string, d Data) (*Foreign, error) {
+	f, ok := d.(*Foreign)
+	if !ok {
+		return nil, fmt.Errorf("%s: not a Go object: %v", name, d)
+	}
+	return f, nil
+}
+
+// matchName returns a matcher for the Go name d. A string must match
+// exactly, a symbol ignores case so folded symbols can name fields.
+func matchName(name string, d Data) (func(string) bool, error) {
+	switch s := d.(type) {
+	case String:
+		return func(n string) bool { return n == string(s) }, nil
+	case Symbol:
+		return func(n string) bool { return strings.EqualFold(n, string(s)) }, nil
+	}
+	return nil, fmt.Errorf("%s: name must be a string or symbol: %v", name, d)
+}
+
+// indirect follows pointers from the value held by f.
+func indirect(name string, f *Foreign) (reflect.Value, error) {
+	v := reflect.ValueOf(f.v)
+	for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return v, fmt.Errorf("%s: nil %v", name, v.Type())
+		}
+		v = v.Elem()
+	}
+	return v, nil
+}
+
+func _goField(obj, field Data) (Data, error) {
+	f, err := getForeign("go-field", obj)
+	if err != nil {
+		return nil, err
+	}
+	v, err := indirect("go-field", f)
+	if err != nil {
+		return nil, err
+	}
+	if v.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("go-field: not a struct: %v", obj)
+	}
+	match, err := matchName("go-field", field)
+	if err != nil {
+		return nil, err
+	}
+	sf, ok := v.Type().FieldByNameFunc(match)
+	if !ok {
+		return nil, fmt.Errorf("go-field: %v has no field %v", obj, field)
+	}
+	if sf.PkgPath != "" {
+		return nil, fmt.Errorf("go-field: field %s is not exported", sf.Name)
+	}
+	return new(converter).fromGo(v.FieldByIndex(sf.Index))
+}
+
+// goElement returns the element of the slice, array, string or map held
+// by obj at key. A missing map entry gives an invalid Value.
+func goElement(name string, obj, key Data) (reflect.Value, error) {
+	f, err := getForeign(name, obj)
+	if err != nil {
+		return reflect.Value{}, err
+	}
+	v, err := indirect(name, f)
+	if err != nil {
+		return v, err
+	}
+	switch v.Kind() {
+	case reflect.Slice, reflect.Array, reflect.String:
+		i, err := getIndex(name, key, v.Len())
+		if err != nil {
+			return v, err
+		}
+		return v.Index(i), nil
+	case reflect.Map:
+		k, err := new(converter).toGo(key, v.Type().Key())
+		if err != nil {
+			return v, fmt.Errorf("%s: key: %v", name, err)
+		}
+		return v.MapIndex(k), nil
+	}
+	return v, fmt.Errorf("%s: cannot index %v", name, obj)
+}
+
+func _goIndex(obj, key Data) (Data, error) {
+	e, err := goElement("go-index", obj, key)
+	if err != nil {
+		return nil, err
+	}
+	if !e.IsValid() {
+		return nil, fmt.Errorf("go-index: no value for key %v", key)
+	}
+	return new(converter).fromGo(e)
+}
+
+// goIndexSet stores value at key in the slice, array or map held by
+// obj, so the change is seen by the Go code that shares it.
+func goIndexSet(obj, key, value Data) error {
+	f, err := getForeign("go-index-set!", obj)
+	if err != nil {
+		return err
+	}
+	v, err := indirect("go-index-set!", f)
+	if err != nil {
+		return err
+	}
+	if v.Kind() == reflect.Map {
+		if v.IsNil() {
+			return fmt.Errorf("go-index-set!: nil %v", v.Type())
+		}
+		c := new(converter)
+		k, err := c.toGo(key, v.Type().Key())
+		if err != nil {
+			return fmt.Errorf("go-index-set!: key: %v", err)
+		}
+		e, err := c.toGo(value, v.Type().Elem())
+		if err != nil {
+			return fmt.Errorf("go-index-set!: %v", err)
+		}
+		v.SetMapIndex(k, e)
+		return nil
+	}
+	e, err := goElement("go-index-set!", obj, key)
+	if err != nil {
+		return err
+	}
+	if !e.CanSet() {
+		return fmt.Errorf("go-index-set!: cannot change %v", obj)
+	}
+	x, err := new(converter).toGo(value, e.Type())
+	if err != nil {
+		return fmt.Errorf("go-index-set!: %v", err)
+	}
+	e.Set(x)
+	return nil
+}
@@ -0,0 +1,143 @@
+package rsi
+
+import (
+	"fmt"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+type point struct {
+	X, Y   int
+	Tags   []string
+	hidden int
+}
+
+func (p *point) Scale(k int) *point {
+	return &point{X: p.X * k, Y: p.Y * k}
+}
+
+func (p point) String() string {
+	return fmt.Sprintf("(%d, %d)", p.X, p.Y)
+}
+
+func TestForeign(t *testing.T) {
+	Convey("Go values", t, func() {
+		in := New()
+		p := &point{X: 1, Y: 2, Tags: []string{"a", "b"}}
+		in.Define("p", p)
+		in.Define("config", map[string]interface{}{
+			"name":  "rsi",
+			"sizes": []interface{}{1, 2.5},
+		})
+
+		Convey("are wrapped or converted", func() {
+			cases := []TestCase{
+				{"p", "#<go *rsi.point>", ""},
+				{"(go-object? p)", T, ""},
+				{"(go-object? 1)", False, ""},
+				{"(go-field p \"X\")", 1, ""},
+				{"(go-field p 'y)", 2, ""},
+				{"(go-field p 'tags)", `#("a" "b")`, ""},
+				{"(go-field p 'hidden)", nil, "go-field: field hidden is not exported"},
+				{"(go-field p 'z)", nil, "has no field Z"},
+				{"(go-field (go-call p 'scale 3) 'x)", 3, ""},
+				{"(go-call p \"String\")", `"(1, 2)"`, ""},
+				{"(go-call p 'scale \"x\")", nil, "Scale: argument 1: expected an integer"},
+				{"(go-call p 'missing)", nil, "has no method MISSING"},
+				{"(hash-table-ref config \"name\")", `"rsi"`, ""},
+				{"(hash-table-ref config \"sizes\")", "#(1 2.5)", ""},
+			}
+			doCases("foreign", cases, in.Env())
+		})
+
+		Convey("are indexed in place", func() {
+			nums := []int{1, 2, 3}
+			ages := map[string]int{"ann": 30}
+			in.Define("nums", NewForeign(&nums))
+			in.Define("ages", NewForeign(ages))
+			in.Define("word", NewForeign("go"))
+			cases := []TestCase{
+				{"(go-index nums 1)", 2, ""},
+				{"(go-index nums 3)", nil, "go-index: index 3 out of range"},
+				{"(go-index ages \"ann\")", 30, ""},
+				{"(go-index ages \"bob\")", nil, "go-index: no value for key \"bob\""},
+				{"(go-index ages 1)", nil, "go-index: key: expected a string, got 1"},
+				{"(go-index word 0)", 103, ""},
+				{"(go-index p 0)", nil, "go-index: cannot index #<go *rsi.point>"},
+				{"(go-index-set! nums 0 10)", "OK", ""},
+				{"(go-index-set! nums 1 \"x\")", nil, "go-index-set!: expected an integer, got \"x\""},
+				{"(go-index-set! ages \"bob\" 40)", "OK", ""},
+				{"(go-index-set! word 0 1)", nil, "go-index-set!: cannot change"},
+			}
+			doCases("indexing", cases, in.Env())
+			So(nums, ShouldResemble, []int{10, 2, 3})
+			So(ages, ShouldResemble, map[string]int{"ann": 30, "bob": 40})
+		})
+
+		Convey("convert back to Go", func() {
+			v, err := in.Eval(`(define h (make-hash-table))
+				(hash-table-set! h "list" '(1 "two" #t))
+				(hash-table-set! h "vec" #(a))
+				h`)
+			So(err, ShouldBeNil)
+			g, err := ToGo(v)
+			So(err, ShouldBeNil)
+			So(g, ShouldResemble, map[string]interface{}{
+				"list": []interface{}{1.0, "two", true},
+				"vec":  []interface{}{Symbol("A")},
+			})
+			v, err = in.Eval("p")
+			So(err, ShouldBeNil)
+			g, err = ToGo(v)
+			So(err, ShouldBeNil)
+			So(g, ShouldEqual, p)
+
+			var got map[string][]int
+			So(in.DefineFunc("keep", func(m map[string][]int) { got = m }), ShouldBeNil)
+			_, err = in.Eval(`(hash-table-set! h "list" '(1 2)) (hash-table-set! h "vec" #(3)) (keep h)`)
+			So(err, ShouldBeNil)
+			So(got, ShouldResemble, map[string][]int{"list": {1, 2}, "vec": {3}})
+			_, err = in.Eval(`(hash-table-set! h "vec" #(x)) (keep h)`)
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "value of \"vec\": element 0: expected an integer, got X")
+		})
+
+		Convey("refuse to convert cycles", func() {
+			for _, src := range []string{
+				"(define c (list 1 2)) (set-cdr! (cdr c) c) c",
+				"(define l (list 1 2)) (set-car! l l) l",
+				"(define v (vector 1 2)) (vector-set! v 1 (list v)) v",
+			} {
+				v, err := in.Eval(src)
+				So(err, ShouldBeNil)
+				_, err = ToGo(v)
+				So(err, ShouldNotBeNil)
+			}
+			shared, err := in.Eval("(define s '(1)) (list s s)")
+			So(err, ShouldBeNil)
+			g, err := ToGo(shared)
+			So(err, ShouldBeNil)
+			So(g, ShouldResemble, []interface{}{[]interface{}{1.0}, []interface{}{1.0}})
+			So(in.DefineFunc("count", func(xs []interface{}) int { return len(xs) }), ShouldBeNil)
+			_, err = in.Eval("(count v)")
+			So(err, ShouldNotBeNil)
+
+			s := []interface{}{1, nil}
+			s[1] = s
+			_, err = FromGo(s)
+			So(err, ShouldNotBeNil)
+			So(in.Define("s", s), ShouldNotBeNil)
+			m := map[string]interface{}{}
+			m["self"] = m
+			_, err = FromGo(m)
+			So(err, ShouldNotBeNil)
+			_, err = in.Call("count", m)
+			So(err, ShouldNotBeNil)
+			inner := []int{1}
+			d, err := FromGo([]interface{}{inner, inner})
+			So(err, ShouldBeNil)
+			So(fmt.Sprint(d), ShouldEqual, "#(#(1) #(1))")
+		})
+	})
+}
@@ -0,0 +1,122 @@
+package rsi
+
+import (
+	"fmt"
+	"reflect"
+)
+
+var errorType = reflect.TypeOf((*error)(nil)).Elem()
+
+// DefineFunc binds name to a procedure that calls the Go function fn.
+// See Func for how arguments and results are converted.
+func (in *Interpreter) DefineFunc(name string, fn interface{}, params ...string) error {
+	b, err := in.Func(fn, params...)
+	if err != nil {
+		return fmt.Errorf("%s: %v", name, err)
+	}
+	return in.Define(name, b)
+}
+
+// Func wraps the Go function fn as a procedure. Arguments are converted
+// to the types of fn's parameters as described for ToGo, and a variadic
+// fn accepts any number of trailing arguments. A result is converted
+// with FromGo; a final error result is returned as the error of the
+// call, and a fn without other results returns ok. params names the
+// parameters in error messages, otherwise they are numbered from 1.
+func (in *Interpreter) Func(fn interface{}, params ...string) (*Builtin, error) {
+	fv := reflect.ValueOf(fn)
+	ft := fv.Type()
+	if ft.Kind() != reflect.Func {
+		return nil, fmt.Errorf("not a function: %v", ft)
+	}
+	if len(params) > ft.NumIn() {
+		return nil, fmt.Errorf("%d parameter names given for %d parameters", len(params), ft.NumIn())
+	}
+	nout := ft.NumOut()
+	withErr := nout > 0 && ft.Out(nout-1) == errorType
+	if withErr {
+		nout--
+	}
+	if nout > 1 {
+		return nil, fmt.Errorf("too many results in %v", ft)
+	}
+
+	min, max := ft.NumIn(), ft.NumIn()
+	if ft.IsVariadic() {
+		min, max = min-1, -1
+	}
+	name := func(i int) string {
+		if i < len(params) {
+			return params[i]
+		}
+		return fmt.Sprintf("argument %d", i+1)
+	}
+	ok := in.syms.ok
+	b := NewBuiltin(min, max, nil)
+	b.fn = func(args Data) (Data, error) {
+		c := new(converter)
+		fail := func(format string, a ...interface{}) (Data, error) {
+			err := fmt.Errorf(format, a...)
+			if b.name != "" {
+				err = fmt.Errorf("%v: %v", b.name, err)
+			}
+			return nil, err
+		}
+		var vals []reflect.Value
+		i := 0
+		for ; args != Empty; i++ {
+			p, isPair := args.(*Pair)
+			if !isPair {
+				return fail("improper argument list")
+			}
+			if max >= 0 && i >= max {
+				return fail("expected %s, got %d", arguments(min, max), i+listLen(p))
+			}
+			var t reflect.Type
+			if i < min || !ft.IsVariadic() {
+				t = ft.In(i)
+			} else {
+				t = ft.In(min).Elem()
+			}
+			v, err := c.toGo(p.car, t)
+			if err != nil {
+				return fail("%s: %v", name(i), err)
+			}
+			vals = append(vals, v)
+			args = p.cdr
+		}
+		if i < min {
+			return fail("missing %s", name(i))
+		}
+		out, err := callGo(fv, vals)
+		if err != nil {
+			_, err = fail("%v", err)
+			return nil, &EvalError{Err: err}
+		}
+		if withErr {
+			if err, _ := out[nout].Interface().(error); err != nil {
+				return fail("%v", err)
+			}
+		}
+		if nout == 0 {
+			return ok, nil
+		}
+		v, err := c.fromGo(out[0])
+		if err != nil {
+			return fail("result: %v", err)
+		}
+		return v, nil
+	}
+	return b, nil
+}
+
+// callGo calls fv with vals, returning a panic in the function as an
+// error rather than letting it unwind through the interpreter.
+func callGo(fv reflect.Value, vals []reflect.Value) (out []reflect.Value, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+	return fv.Call(vals), nil
+}
@@ -0,0 +1,103 @@
+package rsi
+
+import (
+	"errors"
+	"math"
+	"strings"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestFunc(t *testing.T) {
+	Convey("Go functions", t, func() {
+		in := New()
+		So(in.DefineFunc("repeat", func(s string, n int) (string, error) {
+			if n < 0 {
+				return "", errors.New("negative count")
+			}
+			return strings.Repeat(s, n), nil
+		}, "s", "times"), ShouldBeNil)
+		So(in.DefineFunc("half", func(x float64) float64 { return x / 2 }), ShouldBeNil)
+		So(in.DefineFunc("sum", func(xs ...int) int {
+			t := 0
+			for _, x := range xs {
+				t += x
+			}
+			return t
+		}), ShouldBeNil)
+		So(in.DefineFunc("first", func(d Data, rest ...interface{}) Data { return d }), ShouldBeNil)
+		So(in.DefineFunc("not-bool", func(b bool) bool { return !b }), ShouldBeNil)
+		So(in.DefineFunc("small", func(b uint8) uint8 { return b }), ShouldBeNil)
+		So(in.DefineFunc("explode", func(i int) int { return []int{}[i] }), ShouldBeNil)
+		var called bool
+		So(in.DefineFunc("touch", func() { called = true }), ShouldBeNil)
+
+		Convey("convert arguments and results", func() {
+			for _, c := range []struct {
+				src string
+				v   Data
+			}{
+				{`(repeat "ab" 3)`, String("ababab")},
+				{"(half 5)", Number(2.5)},
+				{"(sum)", Number(0)},
+				{"(sum 1 2 3)", Number(6)},
+				{"(first 'a 2 \"c\")", Symbol("A")},
+				{"(not-bool #f)", T},
+				{"(small 255)", Number(255)},
+				{"(sum -9223372036854775808)", Number(math.MinInt64)},
+				{"(procedure-arity sum)", Cons(Number(0), False)},
+			} {
+				v, err := in.Eval(c.src)
+				So(err, ShouldBeNil)
+				So(v, ShouldResemble, c.v)
+			}
+			v, err := in.Eval("(touch)")
+			So(err, ShouldBeNil)
+			So(v, ShouldEqual, Symbol("OK"))
+			So(called, ShouldBeTrue)
+		})
+
+		Convey("report errors by parameter", func() {
+			for _, c := range []struct {
+				src string
+				err string
+			}{
+				{`(repeat "ab")`, "REPEAT: expected 2 arguments, received 1"},
+				{`(repeat "ab" 1 2)`, "REPEAT: expected 2 arguments, received 3"},
+				{`(repeat 'ab 1)`, "REPEAT: s: expected a string, got AB"},
+				{`(repeat "ab" 1.5)`, "REPEAT: times: expected an integer, got 1.5"},
+				{`(repeat "ab" -1)`, "REPEAT: negative count"},
+				{`(sum 1 "2")`, "SUM: argument 2: expected an integer, got \"2\""},
+				{"(not-bool 0)", "NOT-BOOL: argument 1: expected a boolean, got 0"},
+				{"(small 256)", "SMALL: argument 1: 256 is out of range for uint8"},
+				{"(small -1)", "SMALL: argument 1: expected a non-negative integer, got -1"},
+				{"(sum 100000000000000000000)", "SUM: argument 1: 1e+20 is out of range for int"},
+				{"(sum -100000000000000000000)", "SUM: argument 1: -1e+20 is out of range for int"},
+				{"(small 100000000000000000000)", "SMALL: argument 1: 1e+20 is out of range for uint8"},
+			} {
+				_, err := in.Eval(c.src)
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldStartWith, c.err)
+			}
+		})
+
+		Convey("report panics as errors", func() {
+			_, err := in.Eval("(+ 1 (explode 3))")
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldStartWith, "EXPLODE: panic: runtime error: index out of range")
+			var e *EvalError
+			So(errors.As(err, &e), ShouldBeTrue)
+			So(e.Trace, ShouldNotBeEmpty)
+			v, err := in.Eval("(half 4)")
+			So(err, ShouldBeNil)
+			So(v, ShouldEqual, Number(2))
+		})
+
+		Convey("are checked when registered", func() {
+			So(in.DefineFunc("bad", 42), ShouldNotBeNil)
+			So(in.DefineFunc("bad", func() (int, int) { return 1, 2 }), ShouldNotBeNil)
+			So(in.DefineFunc("bad", func(int) {}, "a", "b"), ShouldNotBeNil)
+		})
+	})
+}
@@ -0,0 +1,200 @@
+package rsi
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+)
+
+// HashTable maps keys to values. Keys are compared with eqv?, so strings
+// and numbers match by value and pairs by identity.
+type HashTable struct {
+	m map[Data]entry // by the hashKey of each key
+}
+
+type entry struct {
+	key, value Data
+}
+
+// numberKey is the hash key of a number: its bits, so that 0 and -0 are
+// different keys and a NaN can be found again, as eqv? has it.
+type numberKey uint64
+
+func hashKey(d Data) Data {
+	if n, ok := d.(Number); ok {
+		return numberKey(math.Float64bits(float64(n)))
+	}
+	return d
+}
+
+// NewHashTable returns an empty hash table.
+func NewHashTable() *HashTable {
+	return &HashTable{m: make(map[Data]entry)}
+}
+
+// Get returns the value stored under key.
+func (h *HashTable) Get(key Data) (Data, bool) {
+	if !hashable(key) {
+		return nil, false
+	}
+	e, ok := h.m[hashKey(key)]
+	return e.value, ok
+}
+
+// Set stores value under key.
+func (h *HashTable) Set(key, value Data) error {
+	if !hashable(key) {
+		return fmt.Errorf("cannot be used as a key: %v", key)
+	}
+	h.m[hashKey(key)] = entry{key, value}
+	return nil
+}
+
+// Delete removes the value stored under key, if any.
+func (h *HashTable) Delete(key Data) {
+	if hashable(key) {
+		delete(h.m, hashKey(key))
+	}
+}
+
+// Len returns the number of entries.
+func (h *HashTable) Len() int {
+	return len(h.m)
+}
+
+func (h *HashTable) String() string {
+	return fmt.Sprintf("#<hash-table %d>", len(h.m))
+}
+
+func hashable(d Data) bool {
+	return d != nil && reflect.TypeOf(d).Comparable()
+}
+
+func bindHashTables(env *Env) {
+	env.BindName("make-hash-table", NewBuiltin(0, 0, func(Data) (Data, error) {
+		return NewHashTable(), nil
+	}))
+	env.BindName("hash-table?", Apply1(func(a Data) (Data, error) {
+		_, ok := a.(*HashTable)
+		return Boolean(ok), nil
+	}))
+	env.BindName("hash-table-ref", NewBuiltin(2, 3, func(args Data) (Data, error) {
+		h, err := getHashTable("hash-table-ref", car(args))
+		if err != nil {
+			return nil, err
+		}
+		if v, found := h.Get(cadr(args)); found {
+			return v, nil
+		}
+		if nullp(cddr(args)) {
+			return nil, fmt.Errorf("hash-table-ref: no value for key %v", cadr(args))
+		}
+		return env.interp.apply(caddr(args), Empty)
+	}))
+	env.BindName("hash-table-ref/default", NewBuiltin(3, 3, func(args Data) (Data, error) {
+		h, err := getHashTable("hash-table-ref/default", car(args))
+		if err != nil {
+			return nil, err
+		}
+		if v, found := h.Get(cadr(args)); found {
+			return v, nil
+		}
+		return caddr(args), nil
+	}))
+	env.BindName("hash-table-set!", NewBuiltin(3, 3, func(args Data) (Data, error) {
+		h, err := getHashTable("hash-table-set!", car(args))
+		if err != nil {
+			return nil, err
+		}
+		if err := h.Set(cadr(args), caddr(args)); err != nil {
+			return nil, fmt.Errorf("hash-table-set!: %v", err)
+		}
+		return env.interp.syms.ok, nil
+	}))
+	env.BindName("hash-table-delete!", Apply2(func(a, key Data) (Data, error) {
+		h, err := getHashTable("hash-table-delete!", a)
+		if err != nil {
+			return nil, err
+		}
+		h.Delete(key)
+		return env.interp.syms.ok, nil
+	}))
+	env.BindName("hash-table-exists?", Apply2(func(a, key Data) (Data, error) {
+		h, err := getHashTable("hash-table-exists?", a)
+		if err != nil {
+			return nil, err
+		}
+		_, found := h.Get(key)
+		return Boolean(found), nil
+	}))
+	env.BindName("hash-table-count", Apply1(func(a Data) (Data, error) {
+		h, err := getHashTable("hash-table-count", a)
+		if err != nil {
+			return nil, err
+		}
+		return Number(h.Len()), nil
+	}))
+	env.BindName("hash-table-keys", Apply1(func(a Data) (Data, error) {
+		h, err := getHashTable("hash-table-keys", a)
+		if err != nil {
+			return nil, err
+		}
+		if err := env.interp.alloc(h.Len()); err != nil {
+			return nil, err
+		}
+		var keys Data = Empty
+		for _, e := range h.m {
+			keys = cons(e.key, keys)
+		}
+		return keys, nil
+	}))
+	env.BindName("hash-table-walk", Apply2(func(a, proc Data) (Data, error) {
+		h, err := getHashTable("hash-table-walk", a)
+		if err != nil {
+			return nil, err
+		}
+		if err := checkProcedure("hash-table-walk", proc); err != nil {
+			return nil, err
+		}
+		// proc may change the table, so walk the keys as they were
+		keys := make([]Data, 0, h.Len())
+		for _, e := range h.m {
+			keys = append(keys, e.key)
+		}
+		for _, k := range keys {
+			if err := env.interp.poll(); err != nil {
+				return nil, err
+			}
+			v, ok := h.Get(k)
+			if !ok {
+				continue
+			}
+			if _, err := env.interp.apply(proc, List(k, v)); err != nil {
+				return nil, err
+			}
+		}
+		return env.interp.syms.ok, nil
+	}))
+	env.BindName("hash-table->alist", Apply1(func(a Data) (Data, error) {
+		h, err := getHashTable("hash-table->alist", a)
+		if err != nil {
+			return nil, err
+		}
+		if err := env.interp.alloc(2 * h.Len()); err != nil {
+			return nil, err
+		}
+		var alist Data = Empty
+		for _, e := range h.m {
+			alist = cons(cons(e.key, e.value), alist)
+		}
+		return alist, nil
+	}))
+}
+
+func getHashTable(name string, d Data) (*HashTable, error) {
+	h, ok := d.(*HashTable)
+	if !ok {
+		return nil, fmt.Errorf("%s: not a hash table: %v", name, d)
+	}
+	return h, nil
+}
@@ -0,0 +1,355 @@
+package rsi
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/rread/rsi/lexer"
+	"github.com/rread/rsi/log"
+)
+
+// Interpreter evaluates Scheme programs. Each interpreter has its own
+// global environment and symbols.
+type Interpreter struct {
+	env  *Env
+	syms *SymbolTable
+
+	// Stdout receives the output of display and newline.
+	Stdout io.Writer
+	// TraceOutput receives the calls made to traced procedures.
+	TraceOutput io.Writer
+	traceDepth  int
+	// Warnings receives the warnings of strict mode.
+	Warnings io.Writer
+	strict   bool
+
+	log           *log.Logger
+	lambdaCounter int
+	engine        Engine
+
+	ctx    context.Context // of the running EvalContext, if any
+	limits Limits
+	steps  int
+	depth  int
+	pairs  int
+	polls  int
+}
+
+// cancelInterval is how many evaluation steps are taken between checks
+// for cancellation.
+const cancelInterval = 1024
+
+// Option configures a new Interpreter.
+type Option func(*options)
+
+type options struct {
+	dialect  Dialect
+	logLevel log.Level
+	limits   Limits
+	engine   Engine
+	strict   bool
+}
+
+// Engine selects how an interpreter evaluates programs.
+type Engine int
+
+const (
+	// Analyzer turns each form into a tree of Go closures and runs it.
+	Analyzer Engine = iota
+	// VM compiles each form to bytecode for a stack machine, with
+	// proper tail calls.
+	VM
+)
+
+// defaultEngine is the engine of interpreters that do not choose one.
+var defaultEngine = Analyzer
+
+func (e Engine) String() string {
+	switch e {
+	case Analyzer:
+		return "analyzer"
+	case VM:
+		return "vm"
+	}
+	return fmt.Sprintf("Engine(%d)", int(e))
+}
+
+// ParseEngine returns the engine called name.
+func ParseEngine(name string) (Engine, error) {
+	switch strings.ToLower(name) {
+	case "analyzer":
+		return Analyzer, nil
+	case "vm":
+		return VM, nil
+	}
+	return Analyzer, fmt.Errorf("unknown engine %q", name)
+}
+
+// WithDialect selects the dialect the interpreter reads, the default is
+// Legacy.
+func WithDialect(d Dialect) Option {
+	return func(o *options) {
+		o.dialect = d
+	}
+}
+
+// WithLogLevel sets the level of the interpreter's debug log, which is
+// written to standard error.
+func WithLogLevel(l log.Level) Option {
+	return func(o *options) {
+		o.logLevel = l
+	}
+}
+
+// WithEngine selects the engine that evaluates programs, the default
+// is Analyzer.
+func WithEngine(e Engine) Option {
+	return func(o *options) {
+		o.engine = e
+	}
+}
+
+// WithStrict turns on warnings, written to Warnings, when a program
+// defines a variable that holds one of the standard procedures.
+func WithStrict() Option {
+	return func(o *options) {
+		o.strict = true
+	}
+}
+
+// New returns an interpreter with the standard procedures defined.
+func New(opts ...Option) *Interpreter {
+	o := options{logLevel: log.Info, engine: defaultEngine}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	in := NewDefaultEnv(o.dialect).interp
+	in.log.SetLevel(o.logLevel)
+	in.limits = o.limits
+	in.engine = o.engine
+	in.strict = o.strict
+	return in
+}
+
+// define binds the global in c to v, warning in strict mode if it is
+// one of the standard procedures.
+func (in *Interpreter) define(c *cell, v Data) {
+	if c.builtin {
+		if in.strict {
+			fmt.Fprintf(in.Warnings, "warning: redefining builtin %v\n", c.name)
+		}
+		c.builtin = false
+	}
+	c.set(v)
+}
+
+// Env returns the global environment.
+func (in *Interpreter) Env() *Env {
+	return in.env
+}
+
+// Dialect returns the dialect the interpreter reads.
+func (in *Interpreter) Dialect() Dialect {
+	return in.syms.dialect
+}
+
+// Engine returns the engine that evaluates programs.
+func (in *Interpreter) Engine() Engine {
+	return in.engine
+}
+
+// Intern returns the symbol for name in the interpreter's dialect.
+func (in *Interpreter) Intern(name string) Symbol {
+	return in.syms.Intern(name)
+}
+
+// SpecialForms returns the symbols that name special forms.
+func (in *Interpreter) SpecialForms() []Symbol {
+	return in.syms.SpecialForms()
+}
+
+// Eval evaluates the forms in src, returning the value of the last one.
+// A program that calls exit or quit stops with an *ExitError, it is up
+// to the caller whether to end the process.
+func (in *Interpreter) Eval(src string) (Data, error) {
+	return in.EvalReader(strings.NewReader(src))
+}
+
+// EvalContext is Eval, stopping early if ctx is cancelled or its
+// deadline passes. The error then wraps ctx.Err(), so errors.Is matches
+// it against context.Canceled or context.DeadlineExceeded. Builtins
+// that work through lists, vectors or hash tables check between
+// elements, so they stop too.
+func (in *Interpreter) EvalContext(ctx context.Context, src string) (Data, error) {
+	return in.EvalReaderContext(ctx, strings.NewReader(src))
+}
+
+// EvalReaderContext is EvalReader with the cancellation of EvalContext.
+func (in *Interpreter) EvalReaderContext(ctx context.Context, r io.Reader) (Data, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, stopped(err)
+	}
+	defer func(prev context.Context) { in.ctx = prev }(in.ctx)
+	in.ctx = ctx
+	return in.EvalReader(r)
+}
+
+// step is called for each evaluation step. It enforces the step limit
+// and every cancelInterval steps checks whether the context has been
+// cancelled.
+func (in *Interpreter) step() error {
+	in.steps++
+	if in.limits.Steps > 0 && in.steps > in.limits.Steps {
+		return &LimitError{"steps", in.limits.Steps}
+	}
+	if in.ctx == nil || in.steps%cancelInterval != 0 {
+		return nil
+	}
+	if err := in.ctx.Err(); err != nil {
+		return stopped(err)
+	}
+	return nil
+}
+
+// poll is called by builtins for each element of a value they work
+// through, which may take a long time without a step being taken. Every
+// cancelInterval calls it checks whether the context has been
+// cancelled. It does not count against the step limit.
+func (in *Interpreter) poll() error {
+	in.polls++
+	if in.ctx == nil || in.polls%cancelInterval != 0 {
+		return nil
+	}
+	if err := in.ctx.Err(); err != nil {
+		return stopped(err)
+	}
+	return nil
+}
+
+func stopped(err error) error {
+	return fmt.Errorf("evaluation stopped: %w", err)
+}
+
+// EvalReader evaluates the forms read from r, returning the value of the
+// last one.
+func (in *Interpreter) EvalReader(r io.Reader) (Data, error) {
+	return in.EvalNamed("eval", r)
+}
+
+// EvalNamed is EvalReader with source locations in errors reported
+// relative to name.
+func (in *Interpreter) EvalNamed(name string, r io.Reader) (Data, error) {
+	in.begin()
+	return replNamed(name, r, in.env)
+}
+
+// Load evaluates the forms in the named file.
+func (in *Interpreter) Load(path string) (Data, error) {
+	in.begin()
+	return loadFile(path, in.env)
+}
+
+// Define binds name to value in the global environment, converting
+// value with FromGo.
+func (in *Interpreter) Define(name string, value interface{}) error {
+	d, err := FromGo(value)
+	if err != nil {
+		return fmt.Errorf("%s: %v", name, err)
+	}
+	in.env.BindName(name, d)
+	return nil
+}
+
+// Lookup returns the global value of name.
+func (in *Interpreter) Lookup(name string) (Data, error) {
+	return in.env.Var(in.Intern(name))
+}
+
+// Call applies proc to args, converted with FromGo. proc is a
+// procedure, or the name of a global one.
+func (in *Interpreter) Call(proc Data, args ...interface{}) (Data, error) {
+	if name, ok := proc.(string); ok {
+		var err error
+		if proc, err = in.Lookup(name); err != nil {
+			return nil, err
+		}
+	}
+	if !procedurep(proc) {
+		return nil, fmt.Errorf("not a procedure: %v", proc)
+	}
+	items := make([]Data, len(args))
+	for i, arg := range args {
+		var err error
+		if items[i], err = FromGo(arg); err != nil {
+			return nil, fmt.Errorf("argument %d: %v", i+1, err)
+		}
+	}
+	in.begin()
+	return in.apply(proc, List(items...))
+}
+
+// Expand reads the form in src and rewrites its derived expressions
+// into the core special forms.
+func (in *Interpreter) Expand(src string) (Data, error) {
+	form, err := newReader(lexer.New("expand", src), in).readDatum()
+	if err != nil {
+		return nil, err
+	}
+	if err := checkCycles(form, in.syms); err != nil {
+		return nil, err
+	}
+	return expand(form, in.syms)
+}
+
+// Trace toggles the tracing of calls to the global procedure name,
+// reporting whether it is now traced.
+func (in *Interpreter) Trace(name string) (bool, error) {
+	v, err := in.Lookup(name)
+	if err != nil {
+		return false, err
+	}
+	on := !traced(v)
+	return on, setTrace(v, on)
+}
+
+// Describe summarizes the type and value of v.
+func Describe(v Data) string {
+	switch p := v.(type) {
+	case *Lambda:
+		return fmt.Sprintf("compound procedure %v taking %s", p, arguments(len(p.params), len(p.params)))
+	case *Builtin, InternalFunc:
+		min, max, _ := procArity(p)
+		kind := "builtin procedure"
+		if traced(p) {
+			kind = "traced " + kind
+		}
+		return fmt.Sprintf("%s %v taking %s", kind, p, arguments(min, max))
+	case Number:
+		return fmt.Sprintf("number %v", p)
+	case String:
+		return fmt.Sprintf("string %v of length %d", p, len(p))
+	case Symbol:
+		return fmt.Sprintf("symbol %v", p)
+	case Boolean:
+		return fmt.Sprintf("boolean %v", p)
+	case Null:
+		return "the empty list"
+	case *Pair:
+		return fmt.Sprintf("pair %v", p)
+	}
+	return fmt.Sprintf("%T %v", v, v)
+}
+
+func arguments(min, max int) string {
+	switch {
+	case max < 0:
+		return fmt.Sprintf("%d or more arguments", min)
+	case min != max:
+		return fmt.Sprintf("%d to %d arguments", min, max)
+	case min == 1:
+		return "1 argument"
+	}
+	return fmt.Sprintf("%d arguments", min)
+}
@@ -0,0 +1,218 @@
+package rsi
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/rread/rsi/log"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestInterpreter(t *testing.T) {
+	Convey("an embedded interpreter", t, func() {
+		var out bytes.Buffer
+		in := New()
+		in.Stdout = &out
+
+		Convey("evaluates source", func() {
+			v, err := in.Eval("(define (twice x) (* x 2)) (twice 4)")
+			So(err, ShouldBeNil)
+			So(v, ShouldEqual, Number(8))
+			v, err = in.EvalReader(strings.NewReader("(display \"hi\") 'done"))
+			So(err, ShouldBeNil)
+			So(v, ShouldEqual, Symbol("DONE"))
+			So(out.String(), ShouldEqual, "hi")
+		})
+
+		Convey("writes shared structure", func() {
+			_, err := in.Eval(`(define s '(1 2))
+				(write (cons s (cons s '("x"))))
+				(write-shared (cons s s))
+				(write-shared '#0=(a . #0#))`)
+			So(err, ShouldBeNil)
+			So(out.String(), ShouldEqual, `((1 2) (1 2) "x")(#0=(1 2) . #0#)#0=(A . #0#)`)
+		})
+
+		Convey("writes strings that read back", func() {
+			v, err := in.Eval(`(define s '("a\"b" "c\\d" #("\"")))
+				(write-shared (cons s s))
+				s`)
+			So(err, ShouldBeNil)
+			So(out.String(), ShouldEqual, `(#0=("a\"b" "c\\d" #("\"")) . #0#)`)
+			w, err := in.Eval("'" + out.String())
+			So(err, ShouldBeNil)
+			So(equal(w, Cons(v, v)), ShouldBeTrue)
+			So(w.(*Pair).car, ShouldEqual, w.(*Pair).cdr)
+			out.Reset()
+			_, err = in.Eval(`(write "back\\slash") (display "back\\slash")`)
+			So(err, ShouldBeNil)
+			So(out.String(), ShouldEqual, `"back\\slash"back\slash`)
+		})
+
+		Convey("shares values with Go", func() {
+			in.Define("limit", Number(10))
+			v, err := in.Eval("(* limit 2)")
+			So(err, ShouldBeNil)
+			So(v, ShouldEqual, Number(20))
+			_, err = in.Eval("(define answer 42)")
+			So(err, ShouldBeNil)
+			v, err = in.Lookup("answer")
+			So(err, ShouldBeNil)
+			So(v, ShouldEqual, Number(42))
+			_, err = in.Lookup("missing")
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("calls procedures", func() {
+			_, err := in.Eval("(define (add a b) (+ a b))")
+			So(err, ShouldBeNil)
+			v, err := in.Call("add", Number(1), Number(2))
+			So(err, ShouldBeNil)
+			So(v, ShouldEqual, Number(3))
+			car, err := in.Lookup("car")
+			So(err, ShouldBeNil)
+			v, err = in.Call(car, List(Number(1), Number(2)))
+			So(err, ShouldBeNil)
+			So(v, ShouldEqual, Number(1))
+			_, err = in.Call("add", Number(1))
+			So(err, ShouldNotBeNil)
+			_, err = in.Call(Number(1))
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("stops when its context ends", func() {
+			_, err := in.Eval("(define (loop n) (if (< n 0) n (loop (+ n 1))))")
+			So(err, ShouldBeNil)
+
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+			_, err = in.EvalContext(ctx, "(loop 0)")
+			So(errors.Is(err, context.Canceled), ShouldBeTrue)
+
+			ctx, cancel = context.WithTimeout(context.Background(), 20*time.Millisecond)
+			defer cancel()
+			_, err = in.EvalContext(ctx, "(+ 1 (loop 0))")
+			So(errors.Is(err, context.DeadlineExceeded), ShouldBeTrue)
+			_, ok := err.(*EvalError)
+			So(ok, ShouldBeTrue)
+
+			v, err := in.EvalContext(context.Background(), "(loop -1)")
+			So(err, ShouldBeNil)
+			So(v, ShouldEqual, Number(-1))
+		})
+
+		Convey("stops builtins working through large values", func() {
+			items := make([]Data, 5000)
+			for i := range items {
+				items[i] = Number(i)
+			}
+			in.Define("big", List(items...))
+			in.Define("other", List(items...))
+			for _, src := range []string{
+				"(equal? (stop big) other)",
+				"(sort (stop big) <)",
+				"(map + (stop big))",
+				"(memv -1 (stop big))",
+			} {
+				ctx, cancel := context.WithCancel(context.Background())
+				So(in.DefineFunc("stop", func(d Data) Data { cancel(); return d }), ShouldBeNil)
+				_, err := in.EvalContext(ctx, src)
+				So(errors.Is(err, context.Canceled), ShouldBeTrue)
+			}
+			v, err := in.Eval("(equal? big other)")
+			So(err, ShouldBeNil)
+			So(v, ShouldEqual, T)
+		})
+
+		Convey("returns exit and quit to the caller", func() {
+			_, err := in.Eval("(exit 2)")
+			So(err, ShouldResemble, &ExitError{2})
+			_, err = in.Eval("(quit)")
+			So(err, ShouldResemble, &ExitError{0})
+		})
+
+		Convey("is independent of other interpreters", func() {
+			other := New(WithDialect(R7RS))
+			_, err := in.Eval("(define x 1)")
+			So(err, ShouldBeNil)
+			_, err = other.Eval("(define x 2)")
+			So(err, ShouldBeNil)
+			v, err := in.Lookup("x")
+			So(err, ShouldBeNil)
+			So(v, ShouldEqual, Number(1))
+			So(other.Dialect(), ShouldEqual, R7RS)
+		})
+	})
+
+	Convey("a strict interpreter", t, func() {
+		var warnings bytes.Buffer
+		in := New(WithStrict())
+		in.Warnings = &warnings
+
+		Convey("warns when a builtin is redefined", func() {
+			_, err := in.Eval("(define (car x) x) (define (car x) 1) (define (mine) 1)")
+			So(err, ShouldBeNil)
+			So(warnings.String(), ShouldEqual, "warning: redefining builtin CAR\n")
+		})
+
+		Convey("only when it is strict", func() {
+			other := New()
+			other.Warnings = &warnings
+			_, err := other.Eval("(define car cdr)")
+			So(err, ShouldBeNil)
+			So(warnings.String(), ShouldEqual, "")
+		})
+	})
+}
+
+func TestParallelInterpreters(t *testing.T) {
+	Convey("interpreters run concurrently without sharing state", t, func() {
+		const n = 8
+		results := make([]string, n)
+		var wg sync.WaitGroup
+		for i := 0; i < n; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				opts := []Option{WithLogLevel(log.Level(i % 2 * int(log.Debug)))}
+				if i%2 == 1 {
+					opts = append(opts, WithDialect(R7RS))
+				}
+				in := New(opts...)
+				in.log = log.New(ioutil.Discard, in.log.Level())
+				in.TraceOutput = ioutil.Discard
+				_, err := in.Eval(`(define (fact n) (if (<= n 1) 1 (* n (fact (- n 1)))))
+					(define id (lambda (x) x))`)
+				if err == nil {
+					_, err = in.Trace("fact")
+				}
+				var v Data
+				if err == nil {
+					v, err = in.Eval(fmt.Sprintf("(define v (make-vector 1 %d)) (id (+ (fact 5) (vector-ref v 0)))", i))
+				}
+				if err != nil {
+					results[i] = err.Error()
+					return
+				}
+				f, _ := in.Lookup("id")
+				results[i] = fmt.Sprint(v, " ", f)
+			}(i)
+		}
+		wg.Wait()
+		for i, r := range results {
+			name := "ID (X)"
+			if i%2 == 1 {
+				name = "id (x)"
+			}
+			So(r, ShouldEqual, fmt.Sprintf("%d #<procedure %s>", 120+i, name))
+		}
+	})
+}
@@ -35,12 +35,42 @@ func TestLexer(t *testing.T) {
 			{"#t", `TRUE "t"`, ""},
 			{"#f", `FALSE "f"`, ""},
 			{"#n", `ILLEGAL "unsupported hash code #n"`, ""},
+			{"#| a #| nested |# comment |#", `COMMENT "| a #| nested |# comment |#"`, ""},
+			{"#| open", `ILLEGAL "unterminated block comment"`, ""},
+			{"#;(a b)", `DATUM_COMMENT ";"`, ""},
+			{"#(1 2)", `VECTOR "("`, ""},
+			{"#12=(a)", `DATUM_LABEL "12"`, ""},
+			{"#0#", `DATUM_REF "0"`, ""},
+			{"#1x", `ILLEGAL "bad datum label #1x"`, ""},
+			{"#!fold-case", `DIRECTIVE "fold-case"`, ""},
+			{"#!no-fold-case", `DIRECTIVE "no-fold-case"`, ""},
+			{"#!/usr/bin/env rsi\n", `COMMENT "!/usr/bin/env rsi\n"`, ""},
+			{" #!/usr/bin/env rsi", `ILLEGAL "unknown directive #!/usr/bin/env"`, ""},
 			{"a(", `SYMBOL "a"`, ""},
 			{"12(", `NUMBER "12"`, ""},
 		} {
 			doLex(c.value, c.expected, c.err)
 		}
 
+		Convey("Token positions", func() {
+			l := New("test", "(a\n  \"b\\\"c\" d)")
+			for _, want := range []string{
+				"test:1:1", "test:1:2", "test:2:4", "test:2:10", "test:2:11",
+			} {
+				So(l.NextItem().Pos.String(), ShouldEqual, want)
+			}
+		})
+
+		Convey("Fold case directives", func() {
+			l := New("test", "Abc #!fold-case Abc #!no-fold-case Abc")
+			for _, want := range []string{
+				`SYMBOL "Abc"`, `DIRECTIVE "fold-case"`, `SYMBOL "abc"`,
+				`DIRECTIVE "no-fold-case"`, `SYMBOL "Abc"`,
+			} {
+				So(l.NextItem().String(), ShouldEqual, want)
+			}
+		})
+
 		Convey("Unknown tokeen", func() {
 			t := Token(500)
 			So(t.String(), ShouldEqual, "Unknown token: 500")
@@ -22,6 +22,11 @@ const (
 	STRING
 	TRUE
 	FALSE
+	DATUM_COMMENT
+	DIRECTIVE
+	VECTOR
+	DATUM_LABEL
+	DATUM_REF
 )
 
 const eof = rune(0)
@@ -52,6 +57,16 @@ func (t Token) String() string {
 		return "TRUE"
 	case FALSE:
 		return "FALSE"
+	case DATUM_COMMENT:
+		return "DATUM_COMMENT"
+	case DIRECTIVE:
+		return "DIRECTIVE"
+	case VECTOR:
+		return "VECTOR"
+	case DATUM_LABEL:
+		return "DATUM_LABEL"
+	case DATUM_REF:
+		return "DATUM_REF"
 	}
 	return "Unknown token: " + fmt.Sprintf("%d", t)
 }
@@ -76,9 +91,24 @@ func isSymbol(ch rune) bool {
 	return isLetter(ch) || isNumber(ch) || ch == '_'
 }
 
+// Pos is the location of a token in the named input.
+type Pos struct {
+	Name string
+	Line int
+	Col  int
+}
+
+func (p Pos) String() string {
+	return fmt.Sprintf("%s:%d:%d", p.Name, p.Line, p.Col)
+}
+
 type TokenItem struct {
 	Token Token
 	Lit   string
+	Pos   Pos
+	// Incomplete is set on an ILLEGAL token caused by input ending
+	// part way through a token, such as an unterminated string.
+	Incomplete bool
 }
 
 func (tok *TokenItem) String() string {
@@ -91,20 +121,40 @@ type Lexer struct {
 	start int
 	pos   int
 	width int
-	items chan *TokenItem
+	state stateFn
+	items []*TokenItem // emitted but not yet returned by NextItem
+
+	// line tracking for token positions
+	line      int
+	lineStart int
+	scanned   int
+	skipped   int
+
+	// foldCase is set by the #!fold-case directive
+	foldCase bool
 }
 
 func New(name, input string) *Lexer {
 	l := &Lexer{
 		name:  name,
 		input: input,
-		items: make(chan *TokenItem)}
-	go l.run()
+		line:  1,
+		state: lexBase}
 	return l
 }
 
+// NextItem returns the next token, running the state machine until one
+// is available. It returns nil once the input is exhausted.
 func (l *Lexer) NextItem() *TokenItem {
-	return <-l.items
+	for len(l.items) == 0 {
+		if l.state == nil {
+			return nil
+		}
+		l.state = l.state(l)
+	}
+	item := l.items[0]
+	l.items = l.items[1:]
+	return item
 }
 
 // peek returns the next rune but leaves the range unchanged.
@@ -130,6 +180,7 @@ func (l *Lexer) skip() {
 	w := l.width
 	l.rewind()
 	l.input = l.input[:l.pos] + l.input[l.pos+w:]
+	l.skipped += w
 }
 
 // ignore skips current input range up to current rune.
@@ -140,9 +191,37 @@ func (l *Lexer) ignore() {
 // emit sends the current range as a t token and resets
 // the range.
 func (l *Lexer) emit(t Token) {
-	l.items <- &TokenItem{Token: t,
-		Lit: l.input[l.start:l.pos]}
+	l.emitLit(t, l.input[l.start:l.pos])
+}
+
+// emitLit is emit with the literal text replaced by lit.
+func (l *Lexer) emitLit(t Token, lit string) {
+	l.items = append(l.items, &TokenItem{Token: t,
+		Lit: lit,
+		Pos: l.position()})
 	l.start = l.pos
+	if l.skipped > 0 {
+		// keep later columns relative to the original input
+		l.scan()
+		l.lineStart -= l.skipped
+		l.skipped = 0
+	}
+}
+
+// position returns the location of the start of the current range.
+func (l *Lexer) position() Pos {
+	l.scan()
+	return Pos{Name: l.name, Line: l.line, Col: l.start - l.lineStart + 1}
+}
+
+// scan counts the lines in the input up to the current range.
+func (l *Lexer) scan() {
+	for ; l.scanned < l.start; l.scanned++ {
+		if l.input[l.scanned] == '\n' {
+			l.line++
+			l.lineStart = l.scanned + 1
+		}
+	}
 }
 
 // rewind moves end of range to previous rune.
@@ -151,13 +230,6 @@ func (l *Lexer) rewind() {
 	l.width = 0
 }
 
-func (l *Lexer) run() {
-	for state := lexBase; state != nil; {
-		state = state(l)
-	}
-	close(l.items)
-}
-
 /*
 func (l *Lexer) accept(valid string) bool {
 	if strings.IndexRune(valid, l.next()) >= 0 {
@@ -182,10 +254,22 @@ func (l *Lexer) acceptRunFn(test func(rune) bool) {
 type stateFn func(l *Lexer) stateFn
 
 func (l *Lexer) errorf(format string, args ...interface{}) stateFn {
-	l.items <- &TokenItem{
-		ILLEGAL,
-		fmt.Sprintf(format, args...),
-	}
+	l.items = append(l.items, &TokenItem{
+		Token: ILLEGAL,
+		Lit:   fmt.Sprintf(format, args...),
+		Pos:   l.position(),
+	})
+	return nil
+}
+
+// incompletef is errorf for input that ends before a token is complete.
+func (l *Lexer) incompletef(format string, args ...interface{}) stateFn {
+	l.items = append(l.items, &TokenItem{
+		Token:      ILLEGAL,
+		Lit:        fmt.Sprintf(format, args...),
+		Pos:        l.position(),
+		Incomplete: true,
+	})
 	return nil
 }
 
@@ -262,12 +346,77 @@ func lexHash(l *Lexer) stateFn {
 		l.emit(TRUE)
 	case ch == 'f':
 		l.emit(FALSE)
+	case ch == '|':
+		return lexBlockComment
+	case ch == ';':
+		l.emit(DATUM_COMMENT)
+	case ch == '!':
+		return lexDirective
+	case ch == '(':
+		l.emit(VECTOR)
+	case ch >= '0' && ch <= '9':
+		return lexLabel
 	default:
 		return l.errorf("unsupported hash code #%v", l.input[l.start:l.pos])
 	}
 	return lexBase
 }
 
+// lexLabel handles the datum label #n= and its reference #n#, emitting
+// the number n.
+func lexLabel(l *Lexer) stateFn {
+	l.acceptRun("0123456789")
+	n := l.input[l.start:l.pos]
+	switch l.next() {
+	case '=':
+		l.emitLit(DATUM_LABEL, n)
+	case '#':
+		l.emitLit(DATUM_REF, n)
+	default:
+		return l.errorf("bad datum label #%v", l.input[l.start:l.pos])
+	}
+	return lexBase
+}
+
+// lexBlockComment skips a #| ... |# comment, which may be nested.
+func lexBlockComment(l *Lexer) stateFn {
+	depth := 1
+	for depth > 0 {
+		switch ch := l.next(); {
+		case ch == eof:
+			return l.incompletef("unterminated block comment")
+		case ch == '#' && l.peek() == '|':
+			l.next()
+			depth++
+		case ch == '|' && l.peek() == '#':
+			l.next()
+			depth--
+		}
+	}
+	l.emit(COMMENT)
+	return lexBase
+}
+
+// lexDirective handles #!fold-case and #!no-fold-case. A #! at the very
+// start of the input is a script interpreter line and is skipped.
+func lexDirective(l *Lexer) stateFn {
+	l.acceptRunFn(isSymbol)
+	name := l.input[l.start+1 : l.pos]
+	switch name {
+	case "fold-case":
+		l.foldCase = true
+	case "no-fold-case":
+		l.foldCase = false
+	default:
+		if l.start == 1 {
+			return lexComment
+		}
+		return l.errorf("unknown directive #!%v", name)
+	}
+	l.emitLit(DIRECTIVE, name)
+	return lexBase
+}
+
 func lexDot(l *Lexer) stateFn {
 	if isWhitespace(l.peek()) {
 		l.emit(DOT)
@@ -278,7 +427,11 @@ func lexDot(l *Lexer) stateFn {
 
 func lexSymbol(l *Lexer) stateFn {
 	l.acceptRunFn(isSymbol)
-	l.emit(SYMBOL)
+	if l.foldCase {
+		l.emitLit(SYMBOL, strings.ToLower(l.input[l.start:l.pos]))
+	} else {
+		l.emit(SYMBOL)
+	}
 	return lexBase
 }
 
@@ -286,12 +439,12 @@ func lexString(l *Lexer) stateFn {
 	for {
 		switch ch := l.next(); {
 		case ch == eof:
-			return l.errorf("unterminated string: '%v'", l.input[l.start:l.pos])
+			return l.incompletef("unterminated string: '%v'", l.input[l.start:l.pos])
 		case ch == '\\':
 			l.skip()
 			ch := l.next()
 			if ch == eof {
-				return l.errorf("unterminated string: %#v", l.input[l.start:l.pos])
+				return l.incompletef("unterminated string: %#v", l.input[l.start:l.pos])
 			}
 		case ch == '"':
 			l.skip()
@@ -0,0 +1,682 @@
+package rsi
+
+import (
+	"fmt"
+	"math"
+	"sort"
+)
+
+// cxrNames are the compositions of car and cdr from caar to cddddr.
+var cxrNames = func() []string {
+	var names []string
+	var add func(path string)
+	add = func(path string) {
+		if len(path) >= 2 {
+			names = append(names, "c"+path+"r")
+		}
+		if len(path) < 4 {
+			add(path + "a")
+			add(path + "d")
+		}
+	}
+	add("")
+	return names
+}()
+
+func bindLists(env *Env) {
+	in := env.interp
+	env.BindName("list", NewBuiltin(0, -1, func(args Data) (Data, error) {
+		items, err := listSlice(args)
+		if err != nil {
+			return nil, err
+		}
+		if err := in.alloc(len(items)); err != nil {
+			return nil, err
+		}
+		return List(items...), nil
+	}))
+	env.BindName("length", Apply1(func(a Data) (Data, error) {
+		items, err := properList("length", a)
+		if err != nil {
+			return nil, err
+		}
+		return Number(len(items)), nil
+	}))
+	env.BindName("append", NewBuiltin(0, -1, func(args Data) (Data, error) {
+		lists, err := listSlice(args)
+		if err != nil {
+			return nil, err
+		}
+		if len(lists) == 0 {
+			return Empty, nil
+		}
+		// the last list is shared, the others are copied in front of it
+		result := lists[len(lists)-1]
+		for i := len(lists) - 2; i >= 0; i-- {
+			items, err := properList("append", lists[i])
+			if err != nil {
+				return nil, err
+			}
+			if err := in.alloc(len(items)); err != nil {
+				return nil, err
+			}
+			for j := len(items) - 1; j >= 0; j-- {
+				result = &Pair{car: items[j], cdr: result}
+			}
+		}
+		return result, nil
+	}))
+	env.BindName("reverse", Apply1(func(a Data) (Data, error) {
+		items, err := properList("reverse", a)
+		if err != nil {
+			return nil, err
+		}
+		if err := in.alloc(len(items)); err != nil {
+			return nil, err
+		}
+		var l Data = Empty
+		for _, item := range items {
+			l = &Pair{car: item, cdr: l}
+		}
+		return l, nil
+	}))
+	env.BindName("list-tail", Apply2(_listTail))
+	env.BindName("list-ref", Apply2(_listRef))
+	env.BindName("list-copy", Apply1(func(a Data) (Data, error) {
+		pairs, tail, err := pairsOf(a)
+		if err != nil {
+			return nil, fmt.Errorf("list-copy: %v", err)
+		}
+		if err := in.alloc(len(pairs)); err != nil {
+			return nil, err
+		}
+		l := tail
+		for i := len(pairs) - 1; i >= 0; i-- {
+			l = &Pair{car: pairs[i].car, cdr: l}
+		}
+		return l, nil
+	}))
+	env.BindName("last-pair", Apply1(func(a Data) (Data, error) {
+		pairs, _, err := pairsOf(a)
+		if err != nil {
+			return nil, fmt.Errorf("last-pair: %v", err)
+		}
+		if len(pairs) == 0 {
+			return nil, fmt.Errorf("last-pair: %v: value is not a pair", a)
+		}
+		return pairs[len(pairs)-1], nil
+	}))
+
+	env.BindName("memq", Apply2(func(x, l Data) (Data, error) {
+		return in.member("memq", x, l, same(eqv))
+	}))
+	env.BindName("memv", Apply2(func(x, l Data) (Data, error) {
+		return in.member("memv", x, l, same(eqv))
+	}))
+	env.BindName("member", NewBuiltin(2, 3, func(args Data) (Data, error) {
+		a, err := getArgs("member", args, 2, 3)
+		if err != nil {
+			return nil, err
+		}
+		eq, err := in.equivalence("member", a[2:], in.equal)
+		if err != nil {
+			return nil, err
+		}
+		return in.member("member", a[0], a[1], eq)
+	}))
+	env.BindName("assq", Apply2(func(x, l Data) (Data, error) {
+		return in.assoc("assq", x, l, same(eqv))
+	}))
+	env.BindName("assv", Apply2(func(x, l Data) (Data, error) {
+		return in.assoc("assv", x, l, same(eqv))
+	}))
+	env.BindName("assoc", NewBuiltin(2, 3, func(args Data) (Data, error) {
+		a, err := getArgs("assoc", args, 2, 3)
+		if err != nil {
+			return nil, err
+		}
+		eq, err := in.equivalence("assoc", a[2:], in.equal)
+		if err != nil {
+			return nil, err
+		}
+		return in.assoc("assoc", a[0], a[1], eq)
+	}))
+
+	// filter, remove and partition split a list by a predicate
+	split := func(name string, pred, l Data) (yes, no []Data, err error) {
+		items, err := properList(name, l)
+		if err != nil {
+			return nil, nil, err
+		}
+		if err := checkProcedure(name, pred); err != nil {
+			return nil, nil, err
+		}
+		for _, item := range items {
+			if err := in.poll(); err != nil {
+				return nil, nil, err
+			}
+			ok, err := in.test(pred, item)
+			if err != nil {
+				return nil, nil, err
+			}
+			if ok {
+				yes = append(yes, item)
+			} else {
+				no = append(no, item)
+			}
+		}
+		return yes, no, nil
+	}
+	env.BindName("filter", Apply2(func(pred, l Data) (Data, error) {
+		items, _, err := split("filter", pred, l)
+		if err != nil {
+			return nil, err
+		}
+		if err := in.alloc(len(items)); err != nil {
+			return nil, err
+		}
+		return List(items...), nil
+	}))
+	env.BindName("remove", Apply2(func(pred, l Data) (Data, error) {
+		_, items, err := split("remove", pred, l)
+		if err != nil {
+			return nil, err
+		}
+		if err := in.alloc(len(items)); err != nil {
+			return nil, err
+		}
+		return List(items...), nil
+	}))
+	env.BindName("partition", Apply2(func(pred, l Data) (Data, error) {
+		yes, no, err := split("partition", pred, l)
+		if err != nil {
+			return nil, err
+		}
+		if err := in.alloc(len(yes) + len(no) + 2); err != nil {
+			return nil, err
+		}
+		return List(List(yes...), List(no...)), nil
+	}))
+	env.BindName("delete", NewBuiltin(2, 3, func(args Data) (Data, error) {
+		a, err := getArgs("delete", args, 2, 3)
+		if err != nil {
+			return nil, err
+		}
+		eq, err := in.equivalence("delete", a[2:], in.equal)
+		if err != nil {
+			return nil, err
+		}
+		items, err := properList("delete", a[1])
+		if err != nil {
+			return nil, err
+		}
+		var kept []Data
+		for _, item := range items {
+			if err := in.poll(); err != nil {
+				return nil, err
+			}
+			found, err := eq(a[0], item)
+			if err != nil {
+				return nil, err
+			}
+			if !found {
+				kept = append(kept, item)
+			}
+		}
+		if err := in.alloc(len(kept)); err != nil {
+			return nil, err
+		}
+		return List(kept...), nil
+	}))
+
+	env.BindName("reduce", NewBuiltin(3, 3, func(args Data) (Data, error) {
+		a, err := getArgs("reduce", args, 3, 3)
+		if err != nil {
+			return nil, err
+		}
+		if err := checkProcedure("reduce", a[0]); err != nil {
+			return nil, err
+		}
+		items, err := properList("reduce", a[2])
+		if err != nil {
+			return nil, err
+		}
+		if len(items) == 0 {
+			return a[1], nil
+		}
+		acc := items[0]
+		for _, item := range items[1:] {
+			if err := in.poll(); err != nil {
+				return nil, err
+			}
+			if acc, err = in.apply(a[0], List(item, acc)); err != nil {
+				return nil, err
+			}
+		}
+		return acc, nil
+	}))
+	env.BindName("fold-left", NewBuiltin(3, -1, func(args Data) (Data, error) {
+		f, acc, rows, err := folding("fold-left", args)
+		if err != nil {
+			return nil, err
+		}
+		for _, row := range rows {
+			if err := in.poll(); err != nil {
+				return nil, err
+			}
+			if acc, err = in.apply(f, List(append([]Data{acc}, row...)...)); err != nil {
+				return nil, err
+			}
+		}
+		return acc, nil
+	}))
+	env.BindName("fold-right", NewBuiltin(3, -1, func(args Data) (Data, error) {
+		f, acc, rows, err := folding("fold-right", args)
+		if err != nil {
+			return nil, err
+		}
+		for i := len(rows) - 1; i >= 0; i-- {
+			if err := in.poll(); err != nil {
+				return nil, err
+			}
+			if acc, err = in.apply(f, List(append(rows[i], acc)...)); err != nil {
+				return nil, err
+			}
+		}
+		return acc, nil
+	}))
+	env.BindName("iota", NewBuiltin(1, 3, func(args Data) (Data, error) {
+		a, err := getArgs("iota", args, 1, 3)
+		if err != nil {
+			return nil, err
+		}
+		n, err := getCount("iota", a[0])
+		if err != nil {
+			return nil, err
+		}
+		start, step := Number(0), Number(1)
+		for i, p := range []*Number{&start, &step} {
+			if i+1 >= len(a) {
+				break
+			}
+			v, ok := a[i+1].(Number)
+			if !ok {
+				return nil, fmt.Errorf("iota: not a number: %v", a[i+1])
+			}
+			*p = v
+		}
+		if err := in.alloc(n); err != nil {
+			return nil, err
+		}
+		items := make([]Data, n)
+		for i := range items {
+			if err := in.poll(); err != nil {
+				return nil, err
+			}
+			items[i] = start + Number(i)*step
+		}
+		return List(items...), nil
+	}))
+	env.BindName("any", NewBuiltin(2, -1, func(args Data) (Data, error) {
+		pred, rows, err := mapping("any", args)
+		if err != nil {
+			return nil, err
+		}
+		for _, row := range rows {
+			if err := in.poll(); err != nil {
+				return nil, err
+			}
+			v, err := in.apply(pred, List(row...))
+			if err != nil {
+				return nil, err
+			}
+			if isTrue(v) {
+				return v, nil
+			}
+		}
+		return False, nil
+	}))
+	env.BindName("every", NewBuiltin(2, -1, func(args Data) (Data, error) {
+		pred, rows, err := mapping("every", args)
+		if err != nil {
+			return nil, err
+		}
+		var v Data = T
+		for _, row := range rows {
+			if err := in.poll(); err != nil {
+				return nil, err
+			}
+			if v, err = in.apply(pred, List(row...)); err != nil {
+				return nil, err
+			}
+			if !isTrue(v) {
+				return False, nil
+			}
+		}
+		return v, nil
+	}))
+
+	env.BindName("apply", NewBuiltin(2, -1, func(args Data) (Data, error) {
+		a, err := listSlice(args)
+		if err != nil {
+			return nil, err
+		}
+		if len(a) < 2 {
+			return nil, fmt.Errorf("apply: expected a procedure and a list")
+		}
+		if err := checkProcedure("apply", a[0]); err != nil {
+			return nil, err
+		}
+		last, err := properList("apply", a[len(a)-1])
+		if err != nil {
+			return nil, err
+		}
+		return in.apply(a[0], List(append(a[1:len(a)-1:len(a)-1], last...)...))
+	}))
+	env.BindName("map", NewBuiltin(2, -1, func(args Data) (Data, error) {
+		f, rows, err := mapping("map", args)
+		if err != nil {
+			return nil, err
+		}
+		results := make([]Data, len(rows))
+		for i, row := range rows {
+			if err := in.poll(); err != nil {
+				return nil, err
+			}
+			if results[i], err = in.apply(f, List(row...)); err != nil {
+				return nil, err
+			}
+		}
+		if err := in.alloc(len(results)); err != nil {
+			return nil, err
+		}
+		return List(results...), nil
+	}))
+	env.BindName("for-each", NewBuiltin(2, -1, func(args Data) (Data, error) {
+		f, rows, err := mapping("for-each", args)
+		if err != nil {
+			return nil, err
+		}
+		for _, row := range rows {
+			if err := in.poll(); err != nil {
+				return nil, err
+			}
+			if _, err := in.apply(f, List(row...)); err != nil {
+				return nil, err
+			}
+		}
+		return in.syms.ok, nil
+	}))
+	env.BindName("sort", Apply2(func(seq, less Data) (Data, error) {
+		if err := checkProcedure("sort", less); err != nil {
+			return nil, err
+		}
+		var items []Data
+		v, isVector := seq.(*Vector)
+		if isVector {
+			items = append(items, v.items...)
+		} else {
+			var err error
+			if items, err = properList("sort", seq); err != nil {
+				return nil, err
+			}
+		}
+		// the first error from less stops the comparisons that matter
+		var err error
+		sort.SliceStable(items, func(i, j int) bool {
+			if err != nil {
+				return false
+			}
+			if err = in.poll(); err != nil {
+				return false
+			}
+			var ok bool
+			ok, err = in.test(less, items[i], items[j])
+			return ok
+		})
+		if err != nil {
+			return nil, err
+		}
+		if isVector {
+			return NewVector(items...), nil
+		}
+		if err := in.alloc(len(items)); err != nil {
+			return nil, err
+		}
+		return List(items...), nil
+	}))
+
+	for _, name := range cxrNames {
+		env.BindName(name, Apply1(cxr(name)))
+	}
+}
+
+// getArgs returns the list of args to the builtin name, checking that
+// there are from min to max of them.
+func getArgs(name string, args Data, min, max int) ([]Data, error) {
+	a, err := listSlice(args)
+	if err != nil {
+		return nil, err
+	}
+	if len(a) < min || len(a) > max {
+		return nil, fmt.Errorf("%s: expected %s, received %d", name, arguments(min, max), len(a))
+	}
+	return a, nil
+}
+
+// properList returns the elements of the argument l of name, which must
+// be a proper list.
+func properList(name string, l Data) ([]Data, error) {
+	items, err := listSlice(l)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", name, err)
+	}
+	return items, nil
+}
+
+func checkProcedure(name string, d Data) error {
+	if !procedurep(d) {
+		return fmt.Errorf("%s: not a procedure: %v", name, d)
+	}
+	return nil
+}
+
+// getCount returns d as a number of elements, which is at most
+// maxLength.
+func getCount(name string, d Data) (int, error) {
+	k, ok := d.(Number)
+	if !ok || k < 0 || k > maxLength || k != Number(math.Trunc(float64(k))) {
+		return 0, fmt.Errorf("%s: not a non-negative integer: %v", name, d)
+	}
+	return int(k), nil
+}
+
+// getListIndex returns d as an index into a list. An integer too large to
+// be a count is past the end of any list.
+func getListIndex(name string, d Data) (int, error) {
+	k, ok := d.(Number)
+	if ok && k > maxLength && k == Number(math.Trunc(float64(k))) {
+		return 0, fmt.Errorf("%s: index %v out of range", name, d)
+	}
+	return getCount(name, d)
+}
+
+// mapping checks the arguments of a procedure such as any, which are a
+// procedure and one or more lists. It returns the procedure and the rows
+// of arguments to apply it to, the nth elements of each list, up to the
+// end of the shortest list.
+func mapping(name string, args Data) (Data, [][]Data, error) {
+	a, err := listSlice(args)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(a) < 2 {
+		return nil, nil, fmt.Errorf("%s: expected a procedure and a list", name)
+	}
+	if err := checkProcedure(name, a[0]); err != nil {
+		return nil, nil, err
+	}
+	lists := make([][]Data, len(a)-1)
+	n := -1
+	for i, l := range a[1:] {
+		if lists[i], err = properList(name, l); err != nil {
+			return nil, nil, err
+		}
+		if n < 0 || len(lists[i]) < n {
+			n = len(lists[i])
+		}
+	}
+	rows := make([][]Data, n)
+	for i := range rows {
+		rows[i] = make([]Data, len(lists))
+		for j, l := range lists {
+			rows[i][j] = l[i]
+		}
+	}
+	return a[0], rows, nil
+}
+
+// folding is mapping for the folds, whose second argument is the
+// initial value.
+func folding(name string, args Data) (Data, Data, [][]Data, error) {
+	p, err := getPair(cdr(args))
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("%s: expected a procedure, a value and a list", name)
+	}
+	f, rows, err := mapping(name, cons(car(args), p.cdr))
+	return f, p.car, rows, err
+}
+
+// equivalence is a test of whether two values are the same, which may
+// call a procedure.
+type equivalence func(a, b Data) (bool, error)
+
+func same(eq func(a, b Data) bool) equivalence {
+	return func(a, b Data) (bool, error) {
+		return eq(a, b), nil
+	}
+}
+
+// equivalence returns the procedure in the optional args of name as an
+// equivalence, or eq if there is none.
+func (in *Interpreter) equivalence(name string, args []Data, eq equivalence) (equivalence, error) {
+	if len(args) == 0 {
+		return eq, nil
+	}
+	f := args[0]
+	if err := checkProcedure(name, f); err != nil {
+		return nil, err
+	}
+	return func(a, b Data) (bool, error) {
+		return in.test(f, a, b)
+	}, nil
+}
+
+// test applies the predicate pred to args.
+func (in *Interpreter) test(pred Data, args ...Data) (bool, error) {
+	v, err := in.apply(pred, List(args...))
+	if err != nil {
+		return false, err
+	}
+	return bool(isTrue(v)), nil
+}
+
+// member returns the first pair of l whose car is the same as x, or #f.
+func (in *Interpreter) member(name string, x, l Data, eq equivalence) (Data, error) {
+	pairs, _, err := pairsOf(l)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", name, err)
+	}
+	for _, p := range pairs {
+		if err := in.poll(); err != nil {
+			return nil, err
+		}
+		found, err := eq(x, p.car)
+		if err != nil {
+			return nil, err
+		}
+		if found {
+			return p, nil
+		}
+	}
+	return False, nil
+}
+
+// assoc returns the first pair in the association list l whose car is
+// the same as x, or #f.
+func (in *Interpreter) assoc(name string, x, l Data, eq equivalence) (Data, error) {
+	pairs, _, err := pairsOf(l)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", name, err)
+	}
+	for _, p := range pairs {
+		if err := in.poll(); err != nil {
+			return nil, err
+		}
+		entry, ok := p.car.(*Pair)
+		if !ok {
+			return nil, fmt.Errorf("%s: not an association list: %v", name, l)
+		}
+		found, err := eq(x, entry.car)
+		if err != nil {
+			return nil, err
+		}
+		if found {
+			return entry, nil
+		}
+	}
+	return False, nil
+}
+
+func _listTail(l, k Data) (Data, error) {
+	n, err := getListIndex("list-tail", k)
+	if err != nil {
+		return nil, err
+	}
+	for ; n > 0; n-- {
+		p, ok := l.(*Pair)
+		if !ok {
+			return nil, fmt.Errorf("list-tail: index %v out of range", k)
+		}
+		l = p.cdr
+	}
+	return l, nil
+}
+
+func _listRef(l, k Data) (Data, error) {
+	n, err := getListIndex("list-ref", k)
+	if err != nil {
+		return nil, err
+	}
+	for {
+		p, ok := l.(*Pair)
+		if !ok {
+			return nil, fmt.Errorf("list-ref: index %v out of range", k)
+		}
+		if n == 0 {
+			return p.car, nil
+		}
+		l = p.cdr
+		n--
+	}
+}
+
+// cxr returns the composition of car and cdr called name, which applies
+// the letters between c and r from right to left.
+func cxr(name string) func(Data) (Data, error) {
+	path := name[1 : len(name)-1]
+	return func(d Data) (Data, error) {
+		for i := len(path) - 1; i >= 0; i-- {
+			p, err := getPair(d)
+			if err != nil {
+				return nil, fmt.Errorf("%s received: %v", name, err)
+			}
+			if path[i] == 'a' {
+				d = p.car
+			} else {
+				d = p.cdr
+			}
+		}
+		return d, nil
+	}
+}
@@ -0,0 +1,139 @@
+package rsi
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestLists(t *testing.T) {
+	Convey("list procedures", t, func() {
+		env := DefaultEnv()
+		lists := []TestCase{
+			{"(list)", "()", ""},
+			{"(list 1 (+ 1 1) 'c)", "(1 2 C)", ""},
+			{"(length '(1 2 3))", "3", ""},
+			{"(length '())", "0", ""},
+			{"(length '(1 . 2))", nil, "length: 2: not a proper list"},
+			{"(length '#0=(1 . #0#))", nil, "length: "},
+			{"(append)", "()", ""},
+			{"(append '(1) '(2 3) '() '(4))", "(1 2 3 4)", ""},
+			{"(append '(1) 2)", "(1 . 2)", ""},
+			{"(define tail '(3))", "OK", ""},
+			{"(eq? (cdr (append '(1) tail)) tail)", T, ""},
+			{"(append 1 '(2))", nil, "append: 1: not a proper list"},
+			{"(reverse '(1 2 3))", "(3 2 1)", ""},
+			{"(list-tail '(1 2 3) 1)", "(2 3)", ""},
+			{"(list-tail '(1 2 3) 3)", "()", ""},
+			{"(list-tail '(1 2 3) 4)", nil, "list-tail: index 4 out of range"},
+			{"(list-tail '(1 2) 100000000000000000000)", nil, "list-tail: index 1e+20 out of range"},
+			{"(list-ref '(1 2) 100000000000000000000)", nil, "list-ref: index 1e+20 out of range"},
+			{"(list-ref '(a b c) 2)", "C", ""},
+			{"(list-ref '(a b c) 3)", nil, "list-ref: index 3 out of range"},
+			{"(list-ref '(a b c) -1)", nil, "list-ref: not a non-negative integer: -1"},
+			{"(define l '(1 (2) . 3))", "OK", ""},
+			{"(list-copy l)", "(1 (2) . 3)", ""},
+			{"(eq? (list-copy l) l)", False, ""},
+			{"(eq? (cadr (list-copy l)) (cadr l))", T, ""},
+			{"(last-pair l)", "((2) . 3)", ""},
+			{"(last-pair '())", nil, "last-pair: (): value is not a pair"},
+		}
+		doCases("Test building lists", lists, env)
+
+		searches := []TestCase{
+			{"(memq 'c '(a b c d))", "(C D)", ""},
+			{"(memq 'e '(a b c d))", False, ""},
+			{"(memv 2 '(1 2 3))", "(2 3)", ""},
+			{"(memq '(b) '(a (b) c))", False, ""},
+			{"(memq \"b\" '(\"a\" \"b\"))", "(\"b\")", ""},
+			{"(member \"b\" '(\"a\" \"b\"))", "(\"b\")", ""},
+			{"(member '(b) '(a (b) c))", "((B) C)", ""},
+			{"(member 2 '(1 3 5) (lambda (x y) (< x y)))", "(3 5)", ""},
+			{"(member 1 '(1) 2)", nil, "member: not a procedure: 2"},
+			{"(member 1)", nil, "MEMBER: expected 2 to 3 arguments, received 1"},
+			{"(define e '((a 1) (b 2) ((c) 3)))", "OK", ""},
+			{"(assq 'b e)", "(B 2)", ""},
+			{"(assq 'd e)", False, ""},
+			{"(assv 5 '((2 3) (5 7)))", "(5 7)", ""},
+			{"(assq '(c) e)", False, ""},
+			{"(assoc '(c) e)", "((C) 3)", ""},
+			{"(assoc 2.0 '((1 1) (2 4)) =)", "(2 4)", ""},
+			{"(assq 'a '(1))", nil, "assq: not an association list: (1)"},
+		}
+		doCases("Test searching lists", searches, env)
+
+		higher := []TestCase{
+			{"(define (odd? n) (if (= n 0) #f (if (= n 1) #t (odd? (- n 2)))))", "OK", ""},
+			{"(filter odd? '(1 2 3 4 5))", "(1 3 5)", ""},
+			{"(remove odd? '(1 2 3 4 5))", "(2 4)", ""},
+			{"(partition odd? '(1 2 3 4 5))", "((1 3 5) (2 4))", ""},
+			{"(filter 1 '(1))", nil, "filter: not a procedure: 1"},
+			{"(delete 2 '(1 2 3 2))", "(1 3)", ""},
+			{"(delete '(a) '((a) b))", "(B)", ""},
+			{"(delete 2 '(1 2 3) <)", "(1 2)", ""},
+			{"(reduce + 0 '(1 2 3 4))", "10", ""},
+			{"(reduce + 0 '())", "0", ""},
+			{"(reduce cons '() '(1 2 3))", "(3 2 . 1)", ""},
+			{"(fold-left cons '() '(1 2 3))", "(((() . 1) . 2) . 3)", ""},
+			{"(fold-right cons '() '(1 2 3))", "(1 2 3)", ""},
+			{"(fold-left (lambda (acc x y) (+ acc (* x y))) 0 '(1 2 3) '(4 5))", "14", ""},
+			{"(fold-right list 'end '(1 2) '(a b))", "(1 A (2 B END))", ""},
+			{"(fold-left +)", nil, "FOLD-LEFT: expected 3 or more arguments, received 1"},
+			{"(iota 5)", "(0 1 2 3 4)", ""},
+			{"(iota 3 1)", "(1 2 3)", ""},
+			{"(iota 3 0 2)", "(0 2 4)", ""},
+			{"(iota 0)", "()", ""},
+			{"(iota -1)", nil, "iota: not a non-negative integer: -1"},
+			{"(iota 100000000000000000000)", nil, "iota: not a non-negative integer: 1e+20"},
+			{"(any odd? '(2 4 5))", T, ""},
+			{"(any odd? '(2 4))", False, ""},
+			{"(any (lambda (x) (if (> x 1) x #f)) '(1 3 5))", "3", ""},
+			{"(any > '(1 5) '(2 4))", T, ""},
+			{"(every odd? '(1 3 5))", T, ""},
+			{"(every odd? '(1 2 3))", False, ""},
+			{"(every (lambda (x) x) '(1 2 3))", "3", ""},
+			{"(every odd? '())", T, ""},
+		}
+		doCases("Test higher order list procedures", higher, env)
+
+		application := []TestCase{
+			{"(apply + '(1 2 3))", "6", ""},
+			{"(apply + 1 2 '(3 4))", "10", ""},
+			{"(apply list '())", "()", ""},
+			{"(apply (lambda (a b) (- a b)) '(5 3))", "2", ""},
+			{"(apply + 1 2)", nil, "apply: 2: not a proper list"},
+			{"(apply 1 '(2))", nil, "apply: not a procedure: 1"},
+			{"(define args '(1 2))", "OK", ""},
+			{"(eq? (apply list args) args)", False, ""},
+			{"(map car '((a 1) (b 2)))", "(A B)", ""},
+			{"(map (lambda (x) (* x x)) '(1 2 3))", "(1 4 9)", ""},
+			{"(map + '(1 2 3) '(10 20))", "(11 22)", ""},
+			{"(map + '())", "()", ""},
+			{"(map car 1)", nil, "map: 1: not a proper list"},
+			{"(define sum 0)", "OK", ""},
+			{"(for-each (lambda (x y) (set! sum (+ sum (* x y)))) '(1 2) '(3 4))", "OK", ""},
+			{"sum", "11", ""},
+			{"(sort '(3 1 2) <)", "(1 2 3)", ""},
+			{"(sort #(3 1 2) >)", "#(3 2 1)", ""},
+			{"(sort '((b 1) (a 1) (c 0)) (lambda (x y) (< (cadr x) (cadr y))))", "((C 0) (B 1) (A 1))", ""},
+			{"(sort '(1 a) <)", nil, "Not a number: A"},
+			{"(sort '(1 2) 3)", nil, "sort: not a procedure: 3"},
+		}
+		doCases("Test applying procedures", application, env)
+
+		cxrs := []TestCase{
+			{"(define t '((1 2) (3 4) 5 6))", "OK", ""},
+			{"(caar t)", "1", ""},
+			{"(cdar t)", "(2)", ""},
+			{"(cadr t)", "(3 4)", ""},
+			{"(caadr t)", "3", ""},
+			{"(cdadr t)", "(4)", ""},
+			{"(caddr t)", "5", ""},
+			{"(cadddr t)", "6", ""},
+			{"(cddddr t)", "()", ""},
+			{"(caaar t)", nil, "caaar received: 1: value is not a pair"},
+		}
+		doCases("Test car and cdr compositions", cxrs, env)
+		So(len(cxrNames), ShouldEqual, 28)
+	})
+}
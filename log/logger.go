@@ -2,7 +2,9 @@ package log
 
 import (
 	"fmt"
+	"io"
 	"os"
+	"sync/atomic"
 )
 import l "log"
 
@@ -17,10 +19,60 @@ const (
 	Debug
 )
 
-var (
-	level  Level = Info
+// Logger writes messages at or below its level. Each interpreter has its
+// own, so their levels are independent.
+type Logger struct {
+	level  int32
 	logger *l.Logger
+}
+
+// New returns a Logger writing to w.
+func New(w io.Writer, level Level) *Logger {
+	return &Logger{level: int32(level), logger: l.New(w, "", l.LstdFlags|l.Lshortfile)}
+}
+
+func (g *Logger) SetLevel(level Level) {
+	atomic.StoreInt32(&g.level, int32(level))
+}
+
+func (g *Logger) Level() Level {
+	return Level(atomic.LoadInt32(&g.level))
+}
 
+func (g *Logger) output(n Level, s string) {
+	if g.Level() >= n {
+		g.logger.Output(3, s)
+	}
+	if n == FatalLevel {
+		os.Exit(1)
+	}
+}
+
+// logf formats and writes a message, the formatting is skipped when the
+// level is disabled.
+func (g *Logger) logf(n Level, format string, v ...interface{}) {
+	if g.Level() >= n {
+		g.logger.Output(3, fmt.Sprintf(format, v...))
+	}
+}
+
+func (g *Logger) logln(n Level, v ...interface{}) {
+	if g.Level() >= n {
+		g.logger.Output(3, fmt.Sprint(v...))
+	}
+}
+
+func (g *Logger) Debugf(format string, v ...interface{}) { g.logf(Debug, format, v...) }
+func (g *Logger) Printf(format string, v ...interface{}) { g.logf(Debug, format, v...) }
+func (g *Logger) Println(v ...interface{})               { g.logln(Debug, v...) }
+func (g *Logger) Infof(format string, v ...interface{})  { g.logf(Info, format, v...) }
+func (g *Logger) Errorf(format string, v ...interface{}) { g.logf(Error, format, v...) }
+func (g *Logger) Errorln(v ...interface{})               { g.logln(Error, v...) }
+
+// std is used by the package level functions.
+var std = New(os.Stderr, Info)
+
+var (
 	Debugf  = GenLoggerf(Debug)
 	Debugln = GenLoggerln(Debug)
 	Printf  = GenLoggerf(Debug)
@@ -33,34 +85,30 @@ var (
 	Fatal   = GenLoggerln(FatalLevel)
 )
 
-func init() {
-	logger = l.New(os.Stderr, "", l.LstdFlags|l.Lshortfile)
-}
-
 func SetLevel(l Level) {
-	level = l
+	std.SetLevel(l)
 }
 
 func GenLoggerf(l Level) Loggerf {
 	n := l
-	return func(format string, v ...interface{}) {
-		if level >= n {
-			logger.Output(2, fmt.Sprintf(format, v...))
-		}
-		if n == FatalLevel {
-			os.Exit(1)
+	if n == FatalLevel {
+		return func(format string, v ...interface{}) {
+			std.output(n, fmt.Sprintf(format, v...))
 		}
 	}
+	return func(format string, v ...interface{}) {
+		std.logf(n, format, v...)
+	}
 }
 
 func GenLoggerln(l Level) Loggerln {
 	n := l
-	return func(v ...interface{}) {
-		if level >= n {
-			logger.Output(2, fmt.Sprint(v...))
-		}
-		if n == FatalLevel {
-			os.Exit(1)
+	if n == FatalLevel {
+		return func(v ...interface{}) {
+			std.output(n, fmt.Sprint(v...))
 		}
 	}
+	return func(v ...interface{}) {
+		std.logln(n, v...)
+	}
 }
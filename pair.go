@@ -1,35 +1,43 @@
-package main
+package rsi
 
 import (
 	"fmt"
 
-	"github.com/rread/rsi/log"
+	"github.com/rread/rsi/lexer"
 )
 
 type Pair struct {
 	car Data
 	cdr Data
+	pos *lexer.Pos // where the list was read from, if known
 }
 
 func (p *Pair) String() string {
-	ret := "("
-	for {
-		ret += fmt.Sprintf("%v", p.car)
-		if nullp(p.cdr) {
-			break
-		}
-		if pairp(p.cdr) {
-			ret += " "
-			p = p.cdr.(*Pair)
-			continue
-		} else {
-			ret += " . " + fmt.Sprintf("%v", p.cdr)
-			break
-		}
-		break
+	return write(p)
+}
+
+// Car returns the first element of the pair.
+func (p *Pair) Car() Data {
+	return p.car
+}
+
+// Cdr returns the rest of the pair.
+func (p *Pair) Cdr() Data {
+	return p.cdr
+}
+
+// Cons returns a new pair.
+func Cons(car, cdr Data) Data {
+	return &Pair{car: car, cdr: cdr}
+}
+
+// List returns a list of items.
+func List(items ...Data) Data {
+	var l Data = Empty
+	for i := len(items) - 1; i >= 0; i-- {
+		l = &Pair{car: items[i], cdr: l}
 	}
-	ret = ret + ")"
-	return ret
+	return l
 }
 
 func getList(d Data) (Data, error) {
@@ -69,7 +77,7 @@ func cons(car, cdr Data) Data {
 	if getError(cdr) != nil {
 		return cdr
 	}
-	return &Pair{car, cdr}
+	return &Pair{car: car, cdr: cdr}
 }
 
 func cdr(d Data) Data {
@@ -107,9 +115,6 @@ func cadddr(l Data) Data {
 
 func pairp(d Data) Boolean {
 	_, ok := d.(*Pair)
-	if !ok {
-		log.Printf("pairp %T %v %v", d, d, ok)
-	}
 	return Boolean(ok)
 }
 
@@ -122,19 +127,18 @@ func listNext(d Data) (*Pair, error) {
 	return getPair(d)
 }
 
+// listLen counts the pairs in the list d, ignoring an improper tail. A
+// circular list is counted until the cycle is found, as pairsOf does.
 func listLen(d Data) int {
-	if nullp(d) {
-		return 0
-	}
 	var i int
-	for {
+	slow := d
+	for p, ok := d.(*Pair); ok; p, ok = p.cdr.(*Pair) {
 		i++
-		d = cdr(d)
-		if nullp(d) {
-			break
-		}
-		if !pairp(d) {
-			log.Fatal("expecting a list")
+		if i%2 == 0 {
+			slow = slow.(*Pair).cdr
+			if slow == p.cdr {
+				break
+			}
 		}
 	}
 	return i
@@ -151,3 +155,46 @@ func reverse(d Data) Data {
 	}
 	return li
 }
+
+// listSlice returns the elements of the proper list d.
+func listSlice(d Data) ([]Data, error) {
+	var items []Data
+	slow := d
+	for !nullp(d) {
+		p, ok := d.(*Pair)
+		if !ok {
+			return nil, fmt.Errorf("%v: not a proper list", d)
+		}
+		items = append(items, p.car)
+		d = p.cdr
+		// slow follows at half the pace, a circular list catches it up
+		if len(items)%2 == 0 {
+			slow = slow.(*Pair).cdr
+			if slow == d {
+				return nil, fmt.Errorf("%v: circular list", d)
+			}
+		}
+	}
+	return items, nil
+}
+
+// pairsOf returns the pairs of the list d and the value that ends it,
+// which is Empty for a proper list.
+func pairsOf(d Data) ([]*Pair, Data, error) {
+	var pairs []*Pair
+	slow := d
+	for {
+		p, ok := d.(*Pair)
+		if !ok {
+			return pairs, d, nil
+		}
+		pairs = append(pairs, p)
+		d = p.cdr
+		if len(pairs)%2 == 0 {
+			slow = slow.(*Pair).cdr
+			if slow == d {
+				return nil, nil, fmt.Errorf("%v: circular list", d)
+			}
+		}
+	}
+}
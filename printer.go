@@ -0,0 +1,173 @@
+package rsi
+
+import (
+	"fmt"
+	"strings"
+)
+
+// printer writes data, labelling the pairs and vectors that are part of
+// a cycle with #n= where they are first printed and #n# after, so that
+// printing ends.
+type printer struct {
+	strings.Builder
+	labels map[Data]int // 0 until printed, then the label number + 1
+	count  int
+	shared bool // label everything met more than once, not just cycles
+	escape bool // escape the quotes and backslashes in strings
+}
+
+// write returns the printed form of d.
+func write(d Data) string {
+	return newPrinter(false, false).write(d)
+}
+
+// writeDatum returns d as the write procedure writes it, with strings
+// escaped so that reading it back gives the same value.
+func writeDatum(d Data) string {
+	return newPrinter(false, true).write(d)
+}
+
+// writeShared is writeDatum with every pair or vector that occurs more
+// than once labelled, so that reading it back gives the same structure.
+func writeShared(d Data) string {
+	return newPrinter(true, true).write(d)
+}
+
+func newPrinter(shared, escape bool) *printer {
+	return &printer{labels: make(map[Data]int), shared: shared, escape: escape}
+}
+
+var stringEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`)
+
+func (pr *printer) write(d Data) string {
+	pr.scan(d)
+	pr.print(d)
+	return pr.String()
+}
+
+// scan finds the pairs and vectors in d that contain themselves, or in
+// shared mode all those reached twice. It walks d depth first with a
+// stack of its own, as lists can be long; an object reached again while
+// it is still on the stack is in a cycle.
+func (pr *printer) scan(d Data) {
+	active := make(map[Data]bool) // true while on the stack
+	type entry struct {
+		d    Data
+		next int
+	}
+	var stack []entry
+	visit := func(d Data) {
+		switch d.(type) {
+		case *Pair, *Vector:
+		default:
+			return
+		}
+		if on, seen := active[d]; seen {
+			if on || pr.shared {
+				pr.labels[d] = 0
+			}
+			return
+		}
+		active[d] = true
+		stack = append(stack, entry{d: d})
+	}
+	visit(d)
+	for len(stack) > 0 {
+		top := &stack[len(stack)-1]
+		child, ok := nthChild(top.d, top.next)
+		if !ok {
+			active[top.d] = false
+			stack = stack[:len(stack)-1]
+			continue
+		}
+		top.next++
+		visit(child)
+	}
+}
+
+// nthChild returns element i of a pair, its car then its cdr, or of a
+// vector.
+func nthChild(d Data, i int) (Data, bool) {
+	switch x := d.(type) {
+	case *Pair:
+		switch i {
+		case 0:
+			return x.car, true
+		case 1:
+			return x.cdr, true
+		}
+	case *Vector:
+		if i < len(x.items) {
+			return x.items[i], true
+		}
+	}
+	return nil, false
+}
+
+// label writes the label of d, if it has one, reporting whether d has
+// already been printed.
+func (pr *printer) label(d Data) bool {
+	n, ok := pr.labels[d]
+	switch {
+	case !ok:
+		return false
+	case n > 0:
+		fmt.Fprintf(pr, "#%d#", n-1)
+		return true
+	}
+	fmt.Fprintf(pr, "#%d=", pr.count)
+	pr.count++
+	pr.labels[d] = pr.count
+	return false
+}
+
+func (pr *printer) print(d Data) {
+	switch x := d.(type) {
+	case *Pair:
+		pr.list(x)
+	case *Vector:
+		if pr.label(x) {
+			return
+		}
+		pr.WriteString("#(")
+		for i, item := range x.items {
+			if i > 0 {
+				pr.WriteByte(' ')
+			}
+			pr.print(item)
+		}
+		pr.WriteByte(')')
+	case String:
+		if !pr.escape {
+			pr.WriteString(x.String())
+			return
+		}
+		pr.WriteByte('"')
+		stringEscaper.WriteString(pr, string(x))
+		pr.WriteByte('"')
+	default:
+		fmt.Fprint(pr, d)
+	}
+}
+
+func (pr *printer) list(p *Pair) {
+	if pr.label(p) {
+		return
+	}
+	pr.WriteByte('(')
+	for {
+		pr.print(p.car)
+		next, ok := p.cdr.(*Pair)
+		if _, labelled := pr.labels[next]; ok && !labelled {
+			pr.WriteByte(' ')
+			p = next
+			continue
+		}
+		if !nullp(p.cdr) {
+			pr.WriteString(" . ")
+			pr.print(p.cdr)
+		}
+		break
+	}
+	pr.WriteByte(')')
+}
@@ -0,0 +1,93 @@
+package rsi
+
+import "fmt"
+
+// Builtin is a procedure implemented in Go. Its arity is checked when it
+// is applied and can be reported; a max of -1 means any number of
+// arguments.
+type Builtin struct {
+	name   Symbol
+	fn     InternalFunc
+	min    int
+	max    int
+	traced bool
+}
+
+func NewBuiltin(min, max int, fn InternalFunc) *Builtin {
+	return &Builtin{fn: fn, min: min, max: max}
+}
+
+func (b *Builtin) String() string {
+	if b.name == "" {
+		return "#<procedure>"
+	}
+	return fmt.Sprintf("#<procedure %s>", b.name)
+}
+
+// checkArgs returns an error if args is not a number of arguments b
+// accepts.
+func (b *Builtin) checkArgs(args Data) error {
+	n := listLen(args)
+	if n < b.min || b.max >= 0 && n > b.max {
+		return fmt.Errorf("%v: expected %s, received %d", b.name, arguments(b.min, b.max), n)
+	}
+	return nil
+}
+
+func procedurep(d Data) bool {
+	switch d.(type) {
+	case InternalFunc, *Builtin, *Lambda:
+		return true
+	}
+	return false
+}
+
+// procName returns the name a procedure was defined with, if any.
+func procName(d Data) Symbol {
+	switch p := d.(type) {
+	case *Builtin:
+		return p.name
+	case *Lambda:
+		return p.name
+	}
+	return ""
+}
+
+// procArity returns the minimum and maximum number of arguments
+// accepted by a procedure, max is -1 if there is no limit.
+func procArity(d Data) (int, int, error) {
+	switch p := d.(type) {
+	case InternalFunc:
+		return 0, -1, nil
+	case *Builtin:
+		return p.min, p.max, nil
+	case *Lambda:
+		return len(p.params), len(p.params), nil
+	}
+	return 0, 0, fmt.Errorf("not a procedure: %v", d)
+}
+
+func _procedurep(a Data) (Data, error) {
+	return Boolean(procedurep(a)), nil
+}
+
+func _procedureName(a Data) (Data, error) {
+	if !procedurep(a) {
+		return nil, fmt.Errorf("procedure-name: not a procedure: %v", a)
+	}
+	if name := procName(a); name != "" {
+		return name, nil
+	}
+	return False, nil
+}
+
+func _procedureArity(a Data) (Data, error) {
+	min, max, err := procArity(a)
+	if err != nil {
+		return nil, fmt.Errorf("procedure-arity: %v", err)
+	}
+	if max < 0 {
+		return cons(Number(min), False), nil
+	}
+	return cons(Number(min), Number(max)), nil
+}
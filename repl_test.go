@@ -1,4 +1,4 @@
-package main
+package rsi
 
 import (
 	"fmt"
@@ -53,6 +53,14 @@ func TestRepl(t *testing.T) {
 			{"123 ; comment", 123, ""},
 			{"123 ; comment\n", 123, ""},
 			{"#n", nil, "unsupported hash code #n"},
+			{"#| block |# 1", 1, ""},
+			{"#| outer #| inner |# still outer |# 2", 2, ""},
+			{"'(a #;(b c) d)", "(A D)", ""},
+			{"#;1 #;2 3", 3, ""},
+			{"'(a #;)", nil, "#; must be followed by a datum"},
+			{"#!fold-case 'Abc", "ABC", ""},
+			{"#!/usr/bin/env rsi\n4", 4, ""},
+			{"4 #!bogus", "_", "unknown directive #!bogus"},
 			{`"\`, nil, "unterminated string"},
 		}
 
@@ -78,6 +86,27 @@ func TestRepl(t *testing.T) {
 				{"(null? 123)", False, ""},
 				{"(equal? 1 1)", T, ""},
 				{"(equal? 1 30)", False, ""},
+				{"(equal? '(1 (2 #(3)) \"s\") '(1 (2 #(3)) \"s\"))", T, ""},
+				{"(equal? '(1 2) '(1 2 3))", False, ""},
+				{"(equal? #(1 2) #(1 3))", False, ""},
+				{"(equal? car car)", T, ""},
+				{"(eq? '(1) '(1))", False, ""},
+				{"(eqv? '(1) '(1))", False, ""},
+				{"(eq? 'a 'a)", T, ""},
+				{"(eqv? 1.5 1.5)", T, ""},
+				{"(eqv? 0 (* -1 0))", False, ""},
+				{"(eqv? (/ 0 0) (/ 0 0))", T, ""},
+				{"(= (/ 0 0) (/ 0 0))", False, ""},
+				{"(eq? car car)", T, ""},
+				{"(eq? car cdr)", False, ""},
+				{"(eq? (lambda () 1) (lambda () 1))", False, ""},
+				{"(eqv? #(1) #(1))", False, ""},
+				{"(eq? '() '())", T, ""},
+				{"(define s \"abc\")", "OK", ""},
+				{"(eq? s s)", T, ""},
+				{"(eq? s \"abc\")", T, ""},
+				{"(eqv? s \"abc\")", T, ""},
+				{"(eq? 1)", nil, "EQ?: expected 2 arguments, received 1"},
 				{"(pair? '(a b))", T, ""},
 				{"(pair? 30)", False, ""},
 			}
@@ -94,6 +123,7 @@ func TestRepl(t *testing.T) {
 				{"(if '() 'a 'b)", "A", ""},
 				{"(if 0 'a 'b)", "B", ""},
 				{"(if \"\" 'a 'b)", "A", ""},
+				{"(if (car 1) 'a 'b)", nil, "value is not a pair"},
 			}
 			doCases("Conditionals", conditionals, env)
 
@@ -111,8 +141,12 @@ func TestRepl(t *testing.T) {
 				{"(define plus (lambda (a b) (+ a b)))", "OK", ""},
 				{"(plus 10 -2)", 8, ""},
 				{"(plus 10)", nil, "parameter mismatch"},
+				{"(lambda (1) 1)", nil, "bad params: not a symbol 1"},
+				{"(lambda (a \"b\") a)", nil, `bad params: not a symbol "b"`},
+				{"(define (f x #t) x)", nil, "bad params: not a symbol #t"},
 				{"(not-func 10)", nil, "Undefined symbol: NOT-FUNC"},
 				{"(begin (+ 2 3) (* 5 8))", 40, ""},
+				{"(+ 1 1.2.3)", nil, `bad number "1.2.3"`},
 			}
 			doCases("Statements", statements, env)
 		})
@@ -202,6 +236,40 @@ func TestRepl(t *testing.T) {
 		}
 		doCases("factorial", factorial, env)
 
+		procedures := []TestCase{
+			{"(define (add a b) (+ a b))", "OK", ""},
+			{"add", "#<procedure ADD (A B)>", ""},
+			{"car", "#<procedure CAR>", ""},
+			{"(add 1)", nil, "#<procedure ADD (A B)>: parameter mismatch"},
+			{"(procedure? add)", T, ""},
+			{"(procedure? car)", T, ""},
+			{"(procedure? 'add)", False, ""},
+			{"(procedure-name add)", "ADD", ""},
+			{"(procedure-name car)", "CAR", ""},
+			{"(procedure-name (lambda (x) x))", False, ""},
+			{"(procedure-name 1)", nil, "not a procedure"},
+			{"(procedure-arity add)", "(2 . 2)", ""},
+			{"(procedure-arity car)", "(1 . 1)", ""},
+			{"(procedure-arity +)", "(0 . #f)", ""},
+		}
+		doCases("Test named procedures", procedures, env)
+
+		arity := []TestCase{
+			{"(define v (make-vector 2 0))", "OK", ""},
+			{"(vector-set! v 0)", nil, "VECTOR-SET!: expected 3 arguments, received 2"},
+			{"v", "#(0 0)", ""},
+			{"(define h (make-hash-table))", "OK", ""},
+			{"(hash-table-set! h 'k)", nil, "HASH-TABLE-SET!: expected 3 arguments, received 2"},
+			{"(hash-table-count h)", "0", ""},
+			{"(hash-table-ref/default h 1)", nil, "HASH-TABLE-REF/DEFAULT: expected 3 arguments, received 2"},
+			{"(newline 1 2 3)", nil, "NEWLINE: expected 0 arguments, received 3"},
+			{"(make-hash-table 1 2 3)", nil, "MAKE-HASH-TABLE: expected 0 arguments, received 3"},
+			{"(car)", nil, "CAR: expected 1 argument, received 0"},
+			{"(apply vector-set! v '(0))", nil, "VECTOR-SET!: expected 3 arguments, received 2"},
+			{"(map cons '(1 2))", nil, "CONS: expected 2 arguments, received 1"},
+		}
+		doCases("Test the arity of builtins", arity, env)
+
 		letCases := []TestCase{
 			{"(let ((a 1) (b 2)) (+ a b))", Number(3), ""},
 			{"(let ((a 3) (b 2)) (* a b))", Number(6), ""},
@@ -212,6 +280,161 @@ func TestRepl(t *testing.T) {
 		}
 		doCases("Test let statements", letCases, env)
 
+		scopes := []TestCase{
+			{"(define x 'global)", "OK", ""},
+			{"(let ((x 1)) (let ((y 2)) (let ((z 3)) (cons x (cons y (cons z '()))))))", "(1 2 3)", ""},
+			{"(let ((x 1)) ((lambda (x) x) 2))", 2, ""},
+			{"(let ((x 1)) (set! x 5) x)", 5, ""},
+			{"x", "GLOBAL", ""},
+			{"(define (later) (not-yet))", "OK", ""},
+			{"(later)", nil, "Undefined symbol: NOT-YET"},
+			{"(define (not-yet) 'now)", "OK", ""},
+			{"(later)", "NOW", ""},
+			{"(define (f n) (define a (* n 2)) (begin (define b 1)) (+ a b))", "OK", ""},
+			{"(f 5)", 11, ""},
+			{"(define (g) (define c d) (define d 1) c)", "OK", ""},
+			{"(g)", nil, "Undefined symbol: D"},
+			{"(define (h) (if #t (define e 1)))", nil, "cannot define E here"},
+			{"(set! x 'again)", "OK", ""},
+			{"x", "AGAIN", ""},
+			{"(set! undefined-var 1)", nil, "set!: unbound variable UNDEFINED-VAR"},
+			{"undefined-var", nil, "Undefined symbol: UNDEFINED-VAR"},
+			{"(define (early) (set! y 1) (define y 2) y)", "OK", ""},
+			{"(early)", nil, "set!: unbound variable Y"},
+		}
+		doCases("Test lexical scope", scopes, env)
+
+		internal := []TestCase{
+			{`(define (parity n)
+			   (define (ev? n) (if (= n 0) #t (od? (- n 1))))
+			   (define (od? n) (if (= n 0) #f (ev? (- n 1))))
+			   (ev? n))`, "OK", ""},
+			{"(parity 10)", T, ""},
+			{"(parity 7)", False, ""},
+			{"(define (fresh) (define x 0) (set! x (+ x 1)) x)", "OK", ""},
+			{"(fresh)", 1, ""},
+			{"(fresh)", 1, ""},
+			{"(define (sum n) (define here n) (if (= n 0) 0 (+ (sum (- n 1)) here)))", "OK", ""},
+			{"(sum 4)", 10, ""},
+			{"(define (make-counter) (define n 0) (lambda () (set! n (+ n 1)) n))", "OK", ""},
+			{"(define c1 (make-counter))", "OK", ""},
+			{"(define c2 (make-counter))", "OK", ""},
+			{"(c1)", 1, ""},
+			{"(c1)", 2, ""},
+			{"(c2)", 1, ""},
+			{"(letrec* ((a 1) (b (+ a 1))) (* a b))", 2, ""},
+			{`(letrec ((e? (lambda (n) (if (= n 0) #t (o? (- n 1)))))
+			           (o? (lambda (n) (if (= n 0) #f (e? (- n 1))))))
+			   (o? 7))`, T, ""},
+			{"(letrec* ((a b) (b 1)) a)", nil, "Undefined symbol: B"},
+			{"(letrec ((f (lambda () f))) (f))", "#<procedure F ()>", ""},
+			{"(letrec ((a 1)))", nil, "(): value is not a pair"},
+		}
+		doCases("Test internal definitions", internal, env)
+
+		mutable := []TestCase{
+			{"(define p (cons 1 2))", "OK", ""},
+			{"(set-car! p 3)", "OK", ""},
+			{"p", "(3 . 2)", ""},
+			{"(set-cdr! p '(4))", "OK", ""},
+			{"p", "(3 4)", ""},
+			{"(set-car! 1 2)", nil, "set-car!: 1: value is not a pair"},
+			{"(set-cdr! '() 2)", nil, "set-cdr!: (): value is not a pair"},
+			{"(define c (cons 1 '()))", "OK", ""},
+			{"(set-cdr! c c)", "OK", ""},
+			{"c", "#0=(1 . #0#)", ""},
+			{"(define l (cons 1 (cons 2 '())))", "OK", ""},
+			{"(set-cdr! (cdr l) l)", "OK", ""},
+			{"l", "#0=(1 2 . #0#)", ""},
+			{"(cons 0 l)", "(0 . #0=(1 2 . #0#))", ""},
+			{"(set-car! p p)", "OK", ""},
+			{"p", "#0=(#0# 4)", ""},
+			{"(define v (vector 1 2))", "OK", ""},
+			{"(vector-set! v 0 v)", "OK", ""},
+			{"v", "#0=#(#0# 2)", ""},
+			{"(define s '(a))", "OK", ""},
+			{"(cons s s)", "((A) A)", ""},
+			{"(equal? c (cons 1 c))", T, ""},
+			{"(equal? l c)", False, ""},
+			{"(list->vector c)", nil, "circular list"},
+		}
+		doCases("Test mutable pairs", mutable, env)
+
+		labels := []TestCase{
+			{"'#0=(a . #0#)", "#0=(A . #0#)", ""},
+			{"'#1=(a #1#)", "#0=(A #0#)", ""},
+			{"'#0=#(1 #0#)", "#0=#(1 #0#)", ""},
+			{"(define x '(#0=(1) #0#))", "OK", ""},
+			{"(eq? (car x) (car (cdr x)))", T, ""},
+			{"x", "((1) (1))", ""},
+			{"'(#0=(a) #1=(b . #0#) #1#)", "((A) (B A) (B A))", ""},
+			{"'#0#", nil, "undefined datum label #0#"},
+			{"'(#0=1 #0#)", "(1 1)", ""},
+			{"'#0=#0#", nil, "#0= cannot label only itself"},
+			{"'(#0=)", nil, "#0= must be followed by a datum"},
+			{"#0=(a . #0#)", nil, "circular code"},
+			{"#0=(if #t 1 . #0#)", nil, "circular code"},
+			{"#0=(#0#)", nil, "circular code"},
+			{"(car '#0=(1 . #0#))", "1", ""},
+			{"(car (quote #0=(1 . #0#)))", "1", ""},
+			{"(car '#1=(#0=(1) #0# . #1#))", "(1)", ""},
+			{"(cons #0='a #0#)", "(A . A)", ""},
+			{"(eq? #0=car (car (cons #0# 1)))", T, ""},
+		}
+		doCases("Test datum labels", labels, env)
+
+		vectors := []TestCase{
+			{"#(1 (2) \"three\")", `#(1 (2) "three")`, ""},
+			{"(define v (make-vector 3 0))", "OK", ""},
+			{"(vector-set! v 1 'x)", "OK", ""},
+			{"v", "#(0 X 0)", ""},
+			{"(vector-ref v 1)", "X", ""},
+			{"(vector-ref v 3)", nil, "vector-ref: index 3 out of range"},
+			{"(vector-ref v 100000000000000000000)", nil, "vector-ref: index 1e+20 out of range"},
+			{"(vector-length (vector 1 2))", 2, ""},
+			{"(vector? v)", T, ""},
+			{"(vector? '(1))", False, ""},
+			{"(vector->list #(1 2))", "(1 2)", ""},
+			{"(list->vector '(1 2))", "#(1 2)", ""},
+			{"(make-vector -1)", nil, "make-vector: bad length -1"},
+			{"(make-vector 100000000000000000000 0)", nil, "make-vector: bad length 1e+20"},
+		}
+		doCases("Test vectors", vectors, env)
+
+		tables := []TestCase{
+			{"(define h (make-hash-table))", "OK", ""},
+			{"(hash-table-set! h \"a\" 1)", "OK", ""},
+			{"(hash-table-set! h 'b 2)", "OK", ""},
+			{"(hash-table-ref h \"a\")", 1, ""},
+			{"(hash-table-ref h 'c)", nil, "hash-table-ref: no value for key C"},
+			{"(hash-table-ref h 'c (lambda () 0))", 0, ""},
+			{"(hash-table-ref/default h 'b 0)", 2, ""},
+			{"(hash-table-exists? h 'b)", T, ""},
+			{"(hash-table-delete! h 'b)", "OK", ""},
+			{"(hash-table-count h)", 1, ""},
+			{"(hash-table->alist h)", `(("a" . 1))`, ""},
+			{"(hash-table? h)", T, ""},
+			{"(hash-table-set! h 'b 2)", "OK", ""},
+			{"(define total 0)", "OK", ""},
+			{"(hash-table-walk h (lambda (k v) (set! total (+ total v))))", "OK", ""},
+			{"total", 3, ""},
+			{"(hash-table-walk h (lambda (k v) (hash-table-delete! h k)))", "OK", ""},
+			{"(hash-table-count h)", 0, ""},
+			{"(hash-table-walk h car)", "OK", ""},
+			{"(hash-table-walk h 1)", nil, "hash-table-walk: not a procedure: 1"},
+			{"(hash-table-set! h (/ 0 0) 'nan)", "OK", ""},
+			{"(hash-table-ref h (/ 0 0))", "NAN", ""},
+			{"(hash-table-set! h 0 'zero)", "OK", ""},
+			{"(hash-table-set! h (* -1 0) 'minus-zero)", "OK", ""},
+			{"(hash-table-ref h 0)", "ZERO", ""},
+			{"(hash-table-ref h (* -1 0))", "MINUS-ZERO", ""},
+			{"(hash-table-count h)", 3, ""},
+			{"(hash-table-delete! h (/ 0 0))", "OK", ""},
+			{"(apply + (hash-table-keys h))", 0, ""},
+			{"(hash-table-count h)", 2, ""},
+		}
+		doCases("Test hash tables", tables, env)
+
 	})
 }
 
@@ -268,3 +491,64 @@ func doCases(name string, cases []TestCase, env *Env) {
 		}
 	})
 }
+
+func TestDialects(t *testing.T) {
+	Convey("R7RS symbols are case sensitive", t, func() {
+		env := NewDefaultEnv(R7RS)
+		cases := []TestCase{
+			{"'foo", "foo", ""},
+			{"'(a . B)", "(a . B)", ""},
+			{"(define Foo 1)", "ok", ""},
+			{"(define foo 2)", "ok", ""},
+			{"(+ (* 10 Foo) foo)", 12, ""},
+			{"FOO", nil, "Undefined symbol: FOO"},
+			{"(QUOTE x)", nil, "Undefined symbol: QUOTE"},
+			{"(CAR '(1))", nil, "Undefined symbol: CAR"},
+			{"(car '(1))", 1, ""},
+			{"#!fold-case (define XyZ 3) xyz", 3, ""},
+			{"#!fold-case 'ABC #!no-fold-case 'ABC", "ABC", ""},
+			{"#!fold-case (CAR '(A))", "a", ""},
+			{"(define (Sq x) (* x x))", "ok", ""},
+			{"Sq", "#<procedure Sq (x)>", ""},
+		}
+		doCases("case sensitive", cases, env)
+	})
+
+	Convey("Legacy symbols are upper case", t, func() {
+		env := NewDefaultEnv(Legacy)
+		cases := []TestCase{
+			{"'foo", "FOO", ""},
+			{"(define Foo 1)", "OK", ""},
+			{"foo", 1, ""},
+			{"#!no-fold-case 'Foo", "FOO", ""},
+		}
+		doCases("upper case", cases, env)
+	})
+
+	Convey("dialects are named", t, func() {
+		d, err := ParseDialect("R7RS")
+		So(err, ShouldBeNil)
+		So(d, ShouldEqual, R7RS)
+		So(d.String(), ShouldEqual, "r7rs")
+		_, err = ParseDialect("cl")
+		So(err, ShouldNotBeNil)
+	})
+}
+
+func TestBacktrace(t *testing.T) {
+	Convey("errors carry the active applications", t, func() {
+		env := DefaultEnv()
+		_, err := repl("(define (f x) (- x 1))\n(define (g x) (f x))", env)
+		So(err, ShouldBeNil)
+		_, err = repl("(+ 1\n   (g 'atom))", env)
+		So(err, ShouldNotBeNil)
+		So(err.Error(), ShouldEqual, "Not a number: ATOM")
+		e, ok := err.(*EvalError)
+		So(ok, ShouldBeTrue)
+		So(len(e.Trace), ShouldEqual, 3)
+		So(e.Trace[0].String(), ShouldEqual, "- at lispy:1:15")
+		So(e.Trace[1].String(), ShouldEqual, "F at lispy:2:15")
+		So(e.Trace[2].String(), ShouldEqual, "G at lispy:2:4")
+		So(e.Backtrace(), ShouldContainSubstring, "  2: G at lispy:2:4\n")
+	})
+}
@@ -0,0 +1,121 @@
+package rsi
+
+import "fmt"
+
+// Limits caps the resources a program may use, zero means no limit.
+// Steps and Pairs are counted afresh for each call to an Eval method,
+// Load or Call.
+type Limits struct {
+	Steps      int // expressions evaluated
+	Depth      int // procedure applications active at once
+	Pairs      int // pairs created by cons and the procedures that build lists, and elements of new vectors
+	StringSize int // length of a string literal read, in bytes
+}
+
+// sandboxDepth is the Depth of a sandbox given none, as without a limit
+// a deep recursion exhausts the Go stack and aborts the process.
+const sandboxDepth = 10000
+
+// LimitError is returned when a program goes over one of its Limits.
+type LimitError struct {
+	Resource string
+	Limit    int
+}
+
+func (e *LimitError) Error() string {
+	return fmt.Sprintf("%s limit of %d exceeded", e.Resource, e.Limit)
+}
+
+// WithLimits caps the resources used by programs.
+func WithLimits(l Limits) Option {
+	return func(o *options) {
+		o.limits = l
+	}
+}
+
+// SafeBuiltins are the procedures a sandbox has when none are named.
+// None of them reach the file system, the process or Go values.
+var SafeBuiltins = append([]string{
+	"*", "-", "/", "+", "<", "<=", ">", ">=", "=", "number?", "pi",
+	"eq?", "eqv?", "equal?",
+	"cons", "car", "cdr", "set-car!", "set-cdr!", "null?", "pair?",
+	"list", "length", "append", "reverse", "list-tail", "list-ref",
+	"list-copy", "last-pair", "memq", "memv", "member", "assq", "assv",
+	"assoc", "filter", "remove", "partition", "delete", "reduce",
+	"fold-left", "fold-right", "iota", "any", "every",
+	"apply", "map", "for-each", "sort",
+	"procedure?", "procedure-name", "procedure-arity",
+	"display", "write", "write-shared", "newline",
+	"vector", "make-vector", "vector?", "vector-length", "vector-ref",
+	"vector-set!", "vector->list", "list->vector",
+	"make-hash-table", "hash-table?", "hash-table-ref", "hash-table-ref/default",
+	"hash-table-set!", "hash-table-delete!", "hash-table-exists?",
+	"hash-table-count", "hash-table-keys", "hash-table-walk",
+	"hash-table->alist",
+}, cxrNames...)
+
+// unsafe are the builtins a sandbox never has.
+var unsafe = map[string]bool{
+	"load":          true,
+	"exit":          true,
+	"go-object?":    true,
+	"go-field":      true,
+	"go-index":      true,
+	"go-index-set!": true,
+	"go-call":       true,
+}
+
+// NewSandbox returns an interpreter for untrusted programs. Only the
+// named builtins are bound, or SafeBuiltins if there are none, and load,
+// exit, quit and the go- procedures are never available. Combine it with
+// WithLimits to bound the work a program can do; a Depth of zero is
+// replaced by a finite default.
+func NewSandbox(builtins []string, opts ...Option) (*Interpreter, error) {
+	if len(builtins) == 0 {
+		builtins = SafeBuiltins
+	}
+	in := New(opts...)
+	if in.limits.Depth == 0 {
+		in.limits.Depth = sandboxDepth
+	}
+	all := in.env.vars
+	in.env.vars = make(map[Symbol]*cell, len(builtins))
+	for _, name := range builtins {
+		sym := in.Intern(name)
+		c, ok := all[sym]
+		if !ok || !c.bound || unsafe[name] {
+			return nil, fmt.Errorf("sandbox: %s is not an available builtin", name)
+		}
+		in.env.vars[sym] = c
+	}
+	delete(in.syms.forms, in.Intern("quit"))
+	return in, nil
+}
+
+// begin resets the per evaluation counts, unless a program is already
+// running and has called back into the interpreter.
+func (in *Interpreter) begin() {
+	if in.depth == 0 {
+		in.steps = 0
+		in.pairs = 0
+	}
+}
+
+// enter records the start of a procedure application, unless it would
+// go over the depth limit.
+func (in *Interpreter) enter() error {
+	if in.limits.Depth > 0 && in.depth >= in.limits.Depth {
+		return &LimitError{"depth", in.limits.Depth}
+	}
+	in.depth++
+	return nil
+}
+
+// alloc records that n pairs are being created.
+func (in *Interpreter) alloc(n int) error {
+	in.pairs += n
+	if in.limits.Pairs > 0 && in.pairs > in.limits.Pairs {
+		return &LimitError{"pairs", in.limits.Pairs}
+	}
+	return nil
+}
@@ -0,0 +1,125 @@
+package rsi
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestSandbox(t *testing.T) {
+	Convey("a sandbox", t, func() {
+		in, err := NewSandbox(nil, WithLimits(Limits{Steps: 5000, Depth: 50, Pairs: 20, StringSize: 16}))
+		So(err, ShouldBeNil)
+		_, err = in.Eval(`(define (count n) (if (= n 0) 0 (+ 1 (count (- n 1)))))
+			(define (build n) (if (= n 0) '() (cons n (build (- n 1)))))
+			(define (spin n) (spin n))`)
+		So(err, ShouldBeNil)
+
+		Convey("only has safe builtins", func() {
+			cases := []TestCase{
+				{"(car '(1 2))", 1, ""},
+				{"(load \"x.scm\")", nil, "Undefined symbol: LOAD"},
+				{"(exit 1)", nil, "Undefined symbol: EXIT"},
+				{"(quit)", nil, "Undefined symbol: QUIT"},
+				{"(go-field 1 'x)", nil, "Undefined symbol: GO-FIELD"},
+			}
+			doCases("sandboxed", cases, in.Env())
+		})
+
+		Convey("enforces its limits", func() {
+			cases := []TestCase{
+				{"(count 40)", 40, ""},
+				{"(count 60)", nil, "depth limit of 50 exceeded"},
+				{"(build 20)", "_", ""},
+				{"(build 21)", nil, "pairs limit of 20 exceeded"},
+				{"(vector->list (make-vector 21 0))", nil, "pairs limit of 20 exceeded"},
+				{"(iota 21)", nil, "pairs limit of 20 exceeded"},
+				{"(append (iota 10) (iota 10) (iota 10))", nil, "pairs limit of 20 exceeded"},
+				{`"sixteen bytes.."`, "_", ""},
+				{`"seventeen bytes.."`, nil, "string size limit of 16 exceeded"},
+			}
+			doCases("limits", cases, in.Env())
+
+			_, err := in.Eval(strings.Repeat("(count 40) ", 20))
+			var limit *LimitError
+			So(errors.As(err, &limit), ShouldBeTrue)
+			So(limit, ShouldResemble, &LimitError{"steps", 5000})
+
+			// the VM makes spin's tail call by replacing its frame, so the
+			// depth never grows and the loop runs until it has no steps left
+			_, err = in.Eval("(spin 1)")
+			So(errors.As(err, &limit), ShouldBeTrue)
+			if in.Engine() == VM {
+				So(limit, ShouldResemble, &LimitError{"steps", 5000})
+			} else {
+				So(limit, ShouldResemble, &LimitError{"depth", 50})
+			}
+
+			// vectors count against the pairs limit without becoming lists
+			_, err = in.Eval("(make-vector 20 0)")
+			So(err, ShouldBeNil)
+			_, err = in.Eval("(make-vector 21 0)")
+			So(errors.As(err, &limit), ShouldBeTrue)
+			So(limit, ShouldResemble, &LimitError{"pairs", 20})
+			_, err = in.Eval("(make-vector 1000000000 0)")
+			So(errors.As(err, &limit), ShouldBeTrue)
+			So(limit, ShouldResemble, &LimitError{"pairs", 20})
+
+			// the counts start again for each evaluation
+			v, err := in.Eval("(count 40)")
+			So(err, ShouldBeNil)
+			So(v, ShouldEqual, Number(40))
+		})
+
+		Convey("can be given its builtins", func() {
+			in, err := NewSandbox([]string{"+", "display"})
+			So(err, ShouldBeNil)
+			_, err = in.Eval("(car '(1))")
+			So(err, ShouldNotBeNil)
+			_, err = NewSandbox([]string{"+", "load"})
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "load is not an available builtin")
+			_, err = NewSandbox([]string{"no-such-thing"})
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("has a depth limit by default", func() {
+			in, err := NewSandbox(nil)
+			So(err, ShouldBeNil)
+			_, err = in.Eval("(define (count n) (if (= n 0) 0 (+ 1 (count (- n 1))))) (count 20000)")
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "depth limit of 10000 exceeded")
+			v, err := in.Eval("(count 5000)")
+			So(err, ShouldBeNil)
+			So(v, ShouldEqual, Number(5000))
+		})
+
+		Convey("refuses circular code", func() {
+			in, err := NewSandbox(nil, WithLimits(Limits{Steps: 1000}))
+			So(err, ShouldBeNil)
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+			defer cancel()
+			for _, src := range []string{
+				"#0=(#0#)",
+				"#0=(car #0#)",
+				"(lambda () #0=(if #0# 1 2))",
+				"#0=(let ((a 1)) . #0#)",
+			} {
+				_, err := in.EvalContext(ctx, src)
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldContainSubstring, "circular code")
+			}
+		})
+
+		Convey("leaves other interpreters alone", func() {
+			v, err := New().Eval("(procedure? exit)")
+			So(err, ShouldBeNil)
+			So(v, ShouldEqual, T)
+			So(strings.Join(SafeBuiltins, " "), ShouldNotContainSubstring, "go-")
+		})
+	})
+}
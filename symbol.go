@@ -1,4 +1,4 @@
-package main
+package rsi
 
 import (
 	"fmt"
@@ -16,6 +16,7 @@ func StringWithValue(v string) String {
 	return String(v)
 }
 
+// SymbolWithName returns the legacy, upper case, symbol for n.
 func SymbolWithName(n string) Symbol {
 	return Symbol(strings.ToUpper(n))
 }
@@ -25,15 +26,114 @@ func Symbolp(i Data) bool {
 	return ok
 }
 
-var internedSymbols = make(map[string]Symbol, 1024)
+// Dialect selects the language variant an interpreter reads.
+type Dialect int
 
-func internSymbol(name string) Symbol {
-	// Force uppercase symbol names
-	name = strings.ToUpper(name)
-	sym, ok := internedSymbols[name]
+const (
+	// Legacy folds every symbol to upper case, so 'foo prints as FOO.
+	Legacy Dialect = iota
+	// R7RS symbols are case sensitive unless #!fold-case is used.
+	R7RS
+)
+
+func (d Dialect) String() string {
+	switch d {
+	case Legacy:
+		return "legacy"
+	case R7RS:
+		return "r7rs"
+	}
+	return fmt.Sprintf("Dialect(%d)", int(d))
+}
+
+// ParseDialect returns the dialect called name.
+func ParseDialect(name string) (Dialect, error) {
+	switch strings.ToLower(name) {
+	case "legacy":
+		return Legacy, nil
+	case "r7rs":
+		return R7RS, nil
+	}
+	return Legacy, fmt.Errorf("unknown dialect %q", name)
+}
+
+// form identifies a special form.
+type form int
+
+const (
+	formNone form = iota
+	formQuote
+	formDefine
+	formSet
+	formIf
+	formBegin
+	formQuit
+	formLambda
+	formLet
+	formLetrec
+)
+
+var formNames = map[string]form{
+	"quote":   formQuote,
+	"define":  formDefine,
+	"set!":    formSet,
+	"if":      formIf,
+	"begin":   formBegin,
+	"quit":    formQuit,
+	"lambda":  formLambda,
+	"let":     formLet,
+	"letrec":  formLetrec,
+	"letrec*": formLetrec,
+}
+
+// SymbolTable interns the symbols of one interpreter according to its
+// dialect, and knows which of them name special forms.
+type SymbolTable struct {
+	dialect Dialect
+	names   map[string]Symbol
+	forms   map[Symbol]form
+
+	quote  Symbol
+	lambda Symbol
+	define Symbol
+	ok     Symbol
+}
+
+func NewSymbolTable(d Dialect) *SymbolTable {
+	t := &SymbolTable{
+		dialect: d,
+		names:   make(map[string]Symbol, 1024),
+		forms:   make(map[Symbol]form, len(formNames)),
+	}
+	for name, f := range formNames {
+		t.forms[t.Intern(name)] = f
+	}
+	t.quote = t.Intern("quote")
+	t.lambda = t.Intern("lambda")
+	t.define = t.Intern("define")
+	t.ok = t.Intern("ok")
+	return t
+}
+
+// Intern returns the symbol for name.
+func (t *SymbolTable) Intern(name string) Symbol {
+	if t.dialect == Legacy {
+		// Force uppercase symbol names
+		name = strings.ToUpper(name)
+	}
+	sym, ok := t.names[name]
 	if !ok || sym == "" {
-		sym = SymbolWithName(name)
-		internedSymbols[name] = sym
+		sym = Symbol(name)
+		t.names[name] = sym
 	}
 	return sym
 }
+
+// SpecialForms returns the symbols that name special forms.
+func (t *SymbolTable) SpecialForms() []Symbol {
+	syms := make([]Symbol, 0, len(t.forms))
+	for sym := range t.forms {
+		syms = append(syms, sym)
+	}
+	return syms
+}
@@ -0,0 +1,113 @@
+package rsi
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/rread/rsi/lexer"
+)
+
+// maxBacktrace limits how many frames Backtrace prints.
+const maxBacktrace = 20
+
+// Frame is one procedure application that was active when an error
+// occurred.
+type Frame struct {
+	Name string
+	Pos  *lexer.Pos // location of the call, nil if unknown
+}
+
+func (f Frame) String() string {
+	if f.Pos == nil {
+		return f.Name
+	}
+	return fmt.Sprintf("%s at %v", f.Name, *f.Pos)
+}
+
+// EvalError is an error raised while applying a procedure. Trace holds
+// the chain of applications that led to it, innermost first.
+type EvalError struct {
+	Err   error
+	Trace []Frame
+}
+
+func (e *EvalError) Error() string {
+	return e.Err.Error()
+}
+
+// Unwrap returns the underlying error.
+func (e *EvalError) Unwrap() error {
+	return e.Err
+}
+
+// Backtrace formats the trace one frame per line.
+func (e *EvalError) Backtrace() string {
+	var buf bytes.Buffer
+	for i, f := range e.Trace {
+		if i == maxBacktrace {
+			fmt.Fprintf(&buf, "  ... %d more\n", len(e.Trace)-i)
+			break
+		}
+		fmt.Fprintf(&buf, "  %d: %v\n", i, f)
+	}
+	return buf.String()
+}
+
+// addFrame records the application of proc by the expression call on
+// the trace carried by err.
+func addFrame(err error, proc Data, call *Pair) error {
+	if _, ok := err.(*ExitError); ok {
+		return err
+	}
+	e, ok := err.(*EvalError)
+	if !ok {
+		e = &EvalError{Err: err}
+	}
+	name := string(procName(proc))
+	if name == "" {
+		name = fmt.Sprintf("%v", car(call))
+	}
+	e.Trace = append(e.Trace, Frame{Name: name, Pos: call.pos})
+	return e
+}
+
+func (in *Interpreter) traceEnter(name Symbol, args Data) {
+	fmt.Fprintf(in.TraceOutput, "%*s(%v", in.traceDepth+1, "|", name)
+	for ; pairp(args); args = cdr(args) {
+		fmt.Fprintf(in.TraceOutput, " %v", car(args))
+	}
+	fmt.Fprintln(in.TraceOutput, ")")
+	in.traceDepth++
+}
+
+func (in *Interpreter) traceExit(name Symbol, result Data, err error) {
+	in.traceDepth--
+	if err != nil {
+		fmt.Fprintf(in.TraceOutput, "%*s%v !! %v\n", in.traceDepth+1, "|", name, err)
+		return
+	}
+	fmt.Fprintf(in.TraceOutput, "%*s%v => %v\n", in.traceDepth+1, "|", name, result)
+}
+
+// setTrace turns tracing of the procedure d on or off.
+func setTrace(d Data, on bool) error {
+	switch p := d.(type) {
+	case *Lambda:
+		p.traced = on
+	case *Builtin:
+		p.traced = on
+	default:
+		return fmt.Errorf("cannot trace %v", d)
+	}
+	return nil
+}
+
+func traced(d Data) bool {
+	switch p := d.(type) {
+	case *Lambda:
+		return p.traced
+	case *Builtin:
+		return p.traced
+	}
+	return false
+}
@@ -0,0 +1,158 @@
+package rsi
+
+import (
+	"fmt"
+	"math"
+)
+
+// Vector is a fixed length sequence of values, written #(a b c).
+type Vector struct {
+	items []Data
+}
+
+// NewVector returns a vector holding items.
+func NewVector(items ...Data) *Vector {
+	return &Vector{items: items}
+}
+
+// Items returns the elements of the vector.
+func (v *Vector) Items() []Data {
+	return v.items
+}
+
+func (v *Vector) String() string {
+	return write(v)
+}
+
+func bindVectors(env *Env) {
+	env.BindName("vector", NewBuiltin(0, -1, _vector))
+	env.BindName("make-vector", NewBuiltin(1, 2, func(args Data) (Data, error) {
+		// each element counts as a pair, as it takes as much room
+		if n, ok := car(args).(Number); ok && n > 0 && n <= maxLength {
+			if err := env.interp.alloc(int(n)); err != nil {
+				return nil, err
+			}
+		}
+		return _makeVector(args)
+	}))
+	env.BindName("vector?", Apply1(_vectorp))
+	env.BindName("vector-length", Apply1(_vectorLength))
+	env.BindName("vector-ref", Apply2(_vectorRef))
+	env.BindName("vector-set!", NewBuiltin(3, 3, func(args Data) (Data, error) {
+		if err := _vectorSet(args); err != nil {
+			return nil, err
+		}
+		return env.interp.syms.ok, nil
+	}))
+	env.BindName("vector->list", Apply1(func(a Data) (Data, error) {
+		if v, ok := a.(*Vector); ok {
+			if err := env.interp.alloc(len(v.items)); err != nil {
+				return nil, err
+			}
+		}
+		return _vectorToList(a)
+	}))
+	env.BindName("list->vector", Apply1(_listToVector))
+}
+
+func getVector(name string, d Data) (*Vector, error) {
+	v, ok := d.(*Vector)
+	if !ok {
+		return nil, fmt.Errorf("%s: not a vector: %v", name, d)
+	}
+	return v, nil
+}
+
+// getIndex checks that d is a valid index into a sequence of length n.
+func getIndex(name string, d Data, n int) (int, error) {
+	k, ok := d.(Number)
+	if !ok || k != Number(math.Trunc(float64(k))) {
+		return 0, fmt.Errorf("%s: index is not an integer: %v", name, d)
+	}
+	if k < 0 || k >= Number(n) {
+		return 0, fmt.Errorf("%s: index %v out of range", name, d)
+	}
+	return int(k), nil
+}
+
+// maxLength bounds the length of a vector or list made in one go, well
+// within what an int holds.
+const maxLength = math.MaxInt32
+
+func _vector(args Data) (Data, error) {
+	items, err := listSlice(args)
+	if err != nil {
+		return nil, err
+	}
+	return NewVector(items...), nil
+}
+
+func _makeVector(args Data) (Data, error) {
+	n, ok := car(args).(Number)
+	if !ok || n < 0 || n > maxLength || n != Number(math.Trunc(float64(n))) {
+		return nil, fmt.Errorf("make-vector: bad length %v", car(args))
+	}
+	var fill Data = False
+	if !nullp(cdr(args)) {
+		fill = cadr(args)
+	}
+	items := make([]Data, int(n))
+	for i := range items {
+		items[i] = fill
+	}
+	return NewVector(items...), nil
+}
+
+func _vectorp(a Data) (Data, error) {
+	_, ok := a.(*Vector)
+	return Boolean(ok), nil
+}
+
+func _vectorLength(a Data) (Data, error) {
+	v, err := getVector("vector-length", a)
+	if err != nil {
+		return nil, err
+	}
+	return Number(len(v.items)), nil
+}
+
+func _vectorRef(a, k Data) (Data, error) {
+	v, err := getVector("vector-ref", a)
+	if err != nil {
+		return nil, err
+	}
+	i, err := getIndex("vector-ref", k, len(v.items))
+	if err != nil {
+		return nil, err
+	}
+	return v.items[i], nil
+}
+
+func _vectorSet(args Data) error {
+	v, err := getVector("vector-set!", car(args))
+	if err != nil {
+		return err
+	}
+	i, err := getIndex("vector-set!", cadr(args), len(v.items))
+	if err != nil {
+		return err
+	}
+	v.items[i] = caddr(args)
+	return nil
+}
+
+func _vectorToList(a Data) (Data, error) {
+	v, err := getVector("vector->list", a)
+	if err != nil {
+		return nil, err
+	}
+	return List(v.items...), nil
+}
+
+func _listToVector(a Data) (Data, error) {
+	items, err := listSlice(a)
+	if err != nil {
+		return nil, fmt.Errorf("list->vector: %v", err)
+	}
+	return NewVector(items...), nil
+}
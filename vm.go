@@ -0,0 +1,214 @@
+package rsi
+
+// frame is a procedure application running on the VM.
+type frame struct {
+	code *code
+	pc   int
+	env  *Env
+	proc Data  // the procedure applied, nil for the code run was given
+	site *Pair // the expression that applied it
+
+	// tails are the applications this frame has replaced by tail
+	// calls, oldest first. They are only kept for backtraces.
+	tails []caller
+}
+
+type caller struct {
+	proc Data
+	site *Pair
+}
+
+// maxTails limits how many of the applications replaced by tail calls
+// a frame remembers, so loops run in constant space.
+const maxTails = 16
+
+// tailCall makes fr the application of l by site, remembering the
+// application it replaces.
+func (fr *frame) tailCall(l *Lambda, site *Pair, env *Env) {
+	if fr.proc != nil {
+		if len(fr.tails) == maxTails {
+			fr.tails = append(fr.tails[:0], fr.tails[1:]...)
+		}
+		fr.tails = append(fr.tails, caller{fr.proc, fr.site})
+	}
+	fr.code, fr.pc, fr.env = l.code, 0, env
+	fr.proc, fr.site = l, site
+}
+
+// steps are the instructions that take a step when run. Every
+// expression but begin compiles to one of them, so the step limit counts
+// expressions evaluated, as it does for the analyzer.
+var steps = [...]bool{
+	opConst:        true,
+	opGlobal:       true,
+	opLocal:        true,
+	opDefineGlobal: true,
+	opDefineLocal:  true,
+	opSetGlobal:    true,
+	opSetLocal:     true,
+	opJumpFalse:    true,
+	opClosure:      true,
+	opCall:         true,
+	opTailCall:     true,
+	opQuit:         true,
+}
+
+// run executes c in env on a stack machine. Procedures compiled for the
+// VM are applied in new frames rather than by recursion in Go, and
+// applications in tail position replace the frame making them.
+func (in *Interpreter) run(c *code, env *Env) (Data, error) {
+	depth := in.depth
+	defer func() { in.depth = depth }()
+	stack := make([]Data, 0, 32)
+	frames := []frame{{code: c, env: env}}
+	fr := &frames[0]
+	for {
+		i := fr.code.instrs[fr.pc]
+		fr.pc++
+		if steps[i.op] {
+			if err := in.step(); err != nil {
+				return nil, unwind(err, frames)
+			}
+		}
+		switch i.op {
+		case opConst:
+			stack = append(stack, fr.code.consts[i.arg])
+		case opGlobal:
+			v, err := fr.code.consts[i.arg].(*cell).get()
+			if err != nil {
+				return nil, unwind(err, frames)
+			}
+			stack = append(stack, v)
+		case opLocal:
+			v, err := fr.env.frame(int(i.depth)).local(int(i.arg))
+			if err != nil {
+				return nil, unwind(err, frames)
+			}
+			stack = append(stack, v)
+		case opDefineGlobal, opDefineLocal:
+			v := stack[len(stack)-1]
+			if i.op == opDefineGlobal {
+				c := fr.code.consts[i.arg].(*cell)
+				nameLambda(v, c.name)
+				in.define(c, v)
+			} else {
+				fr.env.slots[i.arg] = v
+				nameLambda(v, fr.env.names[i.arg])
+			}
+			// Return value of define is undefined
+			stack[len(stack)-1] = in.syms.ok
+		case opSetGlobal, opSetLocal:
+			var err error
+			if i.op == opSetGlobal {
+				err = fr.code.consts[i.arg].(*cell).assign(stack[len(stack)-1])
+			} else {
+				err = fr.env.frame(int(i.depth)).assign(int(i.arg), stack[len(stack)-1])
+			}
+			if err != nil {
+				return nil, unwind(err, frames)
+			}
+			stack[len(stack)-1] = in.syms.ok
+		case opPop:
+			stack = stack[:len(stack)-1]
+		case opJump:
+			fr.pc = int(i.arg)
+		case opJumpFalse:
+			v := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			if !isTrue(v) {
+				fr.pc = int(i.arg)
+			}
+		case opClosure:
+			k := fr.code.consts[i.arg].(*code)
+			l := in.newLambda()
+			l.params = k.params
+			l.frame = k.frame
+			l.code = k
+			l.envt = fr.env
+			stack = append(stack, l)
+		case opCall, opTailCall:
+			base := len(stack) - int(i.arg) - 1
+			proc, args := stack[base], stack[base+1:]
+			site := fr.code.calls[fr.pc-1]
+			in.log.Printf("procedure call %v", site)
+			l, ok := proc.(*Lambda)
+			if !ok || l.code == nil || l.traced {
+				var v Data
+				var err error
+				if b, ok := proc.(*Builtin); ok && !b.traced {
+					v, err = in.applyBuiltin(b, List(args...))
+				} else {
+					v, err = in.apply(proc, List(args...))
+				}
+				if err != nil {
+					return nil, unwind(addFrame(err, proc, site), frames)
+				}
+				stack = append(stack[:base], v)
+				if i.op == opTailCall {
+					// return v, as opReturn does
+					if len(frames) == 1 {
+						return v, nil
+					}
+					in.depth--
+					frames = frames[:len(frames)-1]
+					fr = &frames[len(frames)-1]
+				}
+				continue
+			}
+			env, err := l.bind(args)
+			if err == nil && i.op == opCall {
+				err = in.enter()
+			}
+			if err != nil {
+				return nil, unwind(addFrame(err, proc, site), frames)
+			}
+			stack = stack[:base]
+			if i.op == opTailCall {
+				fr.tailCall(l, site, env)
+				continue
+			}
+			frames = append(frames, frame{code: l.code, env: env, proc: l, site: site})
+			fr = &frames[len(frames)-1]
+		case opReturn:
+			if len(frames) == 1 {
+				return stack[len(stack)-1], nil
+			}
+			in.depth--
+			frames = frames[:len(frames)-1]
+			fr = &frames[len(frames)-1]
+		case opError:
+			return nil, unwind(fr.code.consts[i.arg].(error), frames)
+		case opQuit:
+			return nil, &ExitError{0}
+		}
+	}
+}
+
+// unwind records the applications active in frames on the trace of
+// err.
+func unwind(err error, frames []frame) error {
+	for i := len(frames) - 1; i >= 0; i-- {
+		fr := frames[i]
+		if fr.proc != nil {
+			err = addFrame(err, fr.proc, fr.site)
+		}
+		for j := len(fr.tails) - 1; j >= 0; j-- {
+			err = addFrame(err, fr.tails[j].proc, fr.tails[j].site)
+		}
+	}
+	return err
+}
+
+// applyBuiltin is apply for an untraced builtin, without the overhead
+// of the general case.
+func (in *Interpreter) applyBuiltin(b *Builtin, args Data) (Data, error) {
+	if err := b.checkArgs(args); err != nil {
+		return nil, err
+	}
+	if err := in.enter(); err != nil {
+		return nil, err
+	}
+	v, err := b.fn(args)
+	in.depth--
+	return v, err
+}
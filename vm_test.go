@@ -0,0 +1,83 @@
+package rsi
+
+import (
+	"bytes"
+	"os"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// TestMain runs every test and benchmark once with each engine.
+func TestMain(m *testing.M) {
+	for _, e := range []Engine{Analyzer, VM} {
+		defaultEngine = e
+		if code := m.Run(); code != 0 {
+			os.Exit(code)
+		}
+	}
+	os.Exit(0)
+}
+
+func TestVM(t *testing.T) {
+	Convey("the VM", t, func() {
+		var out bytes.Buffer
+		in := New(WithEngine(VM), WithLimits(Limits{Depth: 50}))
+		in.Stdout = &out
+		So(in.Engine(), ShouldEqual, VM)
+
+		Convey("makes proper tail calls", func() {
+			cases := []TestCase{
+				{"(define (loop n acc) (if (= n 0) acc (loop (- n 1) (+ acc 1))))", "_", ""},
+				{"(loop 100000 0)", 100000, ""},
+				{"(define (even? n) (if (= n 0) #t (odd? (- n 1))))", "_", ""},
+				{"(define (odd? n) (if (= n 0) #f (even? (- n 1))))", "_", ""},
+				{"(even? 10001)", false, ""},
+				{"(define (count n) (if (= n 0) 0 (+ 1 (count (- n 1)))))", "_", ""},
+				{"(count 40)", 40, ""},
+				{"(count 60)", nil, "depth limit of 50 exceeded"},
+			}
+			doCases("tail calls", cases, in.Env())
+		})
+
+		Convey("keeps closures", func() {
+			cases := []TestCase{
+				{"(define (counter) (let ((n 0)) (lambda () (set! n (+ n 1)) n)))", "_", ""},
+				{"(define c (counter))", "_", ""},
+				{"(c)", 1, ""},
+				{"(c)", 2, ""},
+				{"((counter))", 1, ""},
+				{"(c)", 3, ""},
+			}
+			doCases("closures", cases, in.Env())
+		})
+
+		Convey("disassembles procedures", func() {
+			_, err := in.Eval("(define (f x) (if x (g x) 'no))")
+			So(err, ShouldBeNil)
+			_, err = in.Eval("(disassemble f)")
+			So(err, ShouldBeNil)
+			So(out.String(), ShouldEqual, `#<procedure F (X)>
+   0  local      0 0 ; X
+   1  jump-false 5
+   2  global     G
+   3  local      0 0 ; X
+   4  tail-call  1
+   5  const      NO
+   6  return
+`)
+			_, err = in.Eval("(disassemble car)")
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "not a compiled procedure")
+		})
+	})
+
+	Convey("engines are named", t, func() {
+		e, err := ParseEngine("VM")
+		So(err, ShouldBeNil)
+		So(e, ShouldEqual, VM)
+		So(Analyzer.String(), ShouldEqual, "analyzer")
+		_, err = ParseEngine("jit")
+		So(err, ShouldNotBeNil)
+	})
+}